@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/binary"
 	"flag"
 	"fmt"
 	"image"
@@ -14,7 +13,10 @@ import (
 	"azul3d.org/gfx.v1"
 	"azul3d.org/gfx/window.v2"
 	"azul3d.org/keyboard.v1"
-	"github.com/cquinn/doombot/testing"
+	"github.com/cquinn/doombot/clock"
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+	"github.com/cquinn/doombot/transport"
 	"github.com/xa4a/go-roomba"
 )
 
@@ -26,10 +28,11 @@ const (
 )
 
 var (
+	robotURL   = flag.String("robot", "", "Roomba's address: serial:///dev/ttyUSB0, tcp://host:9003 or sim://world.json. Overrides the flags below.")
 	serialPort = flag.String("serial", defaultSerial, "Local serial port name.")
 	remoteAddr = flag.String("remote", "", "Remote Roomba's network address and port.")
 	testMode   = flag.String("testMode", "", "Set to true to use a mock roomba")
-	modes      = []string{"Off", "Passive", "Safe", "Full"}
+	worldFile  = flag.String("world", "", "JSON world description for the mock roomba.")
 
 	t8  byte = 12 // 16 for 120BPM in theory
 	t4  byte = t8 * 2
@@ -130,67 +133,104 @@ var (
 	}
 )
 
-type TimeEvent struct{}
+// SensorEvent carries the latest sensor readings from the robot's stream.
+type SensorEvent struct {
+	t    time.Time
+	info *SensorInfo
+}
 
-func (e *TimeEvent) Time() time.Time {
-	return time.Now()
+func (e *SensorEvent) Time() time.Time {
+	return e.t
 }
 
 type SensorInfo struct {
-	voltage  uint
-	current  int
-	temp     int
-	charge   uint
-	capacity uint
-	// TODO - add enum for mode (careful, can get whacky modes...)
-	mode      uint
+	voltage   uint16
+	current   int16
+	temp      int8
+	charge    uint16
+	capacity  uint16
+	mode      sensors.Mode
 	bumpleft  bool
 	bumpright bool
 }
 
+// telemetryPackets are the sensor packets that make up a SensorInfo.
+var telemetryPackets = []byte{
+	sensors.PacketVoltage, sensors.PacketCurrent, sensors.PacketTemperature,
+	sensors.PacketBatteryCharge, sensors.PacketBatteryCapacity,
+	sensors.PacketMode, sensors.PacketBumps,
+}
+
 func getSensorInfo(bot *roomba.Roomba) (*SensorInfo, error) {
-	si := &SensorInfo{}
 	log.Println()
 	log.Printf("GETTING SENSOR INFO")
-	// TODO - check for error return from sensor stuff
-	si.voltage, _ = sensorU16(bot, 22)
+	r, err := sensors.Query(bot.S, telemetryPackets...)
+	if err != nil {
+		return nil, err
+	}
+	si := newSensorInfo(r)
 	log.Printf("Voltage: %dmV", si.voltage)
-
-	si.current, _ = sensorS16(bot, 23)
 	log.Printf("Current: %dmA", si.current)
-
-	si.temp, _ = sensorS8(bot, 24)
 	log.Printf("Temp: %dC", si.temp)
-
-	si.charge, _ = sensorU16(bot, 25)
 	log.Printf("Charge: %dmAh", si.charge)
-
-	si.capacity, _ = sensorU16(bot, 26)
 	log.Printf("Capacity: %dmAh", si.capacity)
-
-	si.mode, _ = sensorU8(bot, 35)
-	log.Printf("Mode: %d", si.mode)
-
-	bumps, _ := sensorU8(bot, 7)
-	log.Printf("Bumps: %d", bumps)
-
-	si.bumpright = (bumps&1 != 0)
-	si.bumpleft = (bumps&2 != 0)
-
+	log.Printf("Mode: %v", si.mode)
+	log.Printf("Bumps: %v", r.Bumps)
 	log.Println()
 
 	return si, nil
 }
 
-func makeRemoteRoomba(remoteAddr string) (*roomba.Roomba, error) {
-	// from MakeRoomba()...
-	roomba := &roomba.Roomba{PortName: remoteAddr, StreamPaused: make(chan bool, 1)}
-	conn, err := net.Dial("tcp", remoteAddr)
-	if err != nil {
-		return nil, err
+func newSensorInfo(r *sensors.Readings) *SensorInfo {
+	return &SensorInfo{
+		voltage:   r.Voltage,
+		current:   r.Current,
+		temp:      r.Temperature,
+		charge:    r.BatteryCharge,
+		capacity:  r.BatteryCapacity,
+		mode:      r.Mode,
+		bumpleft:  r.Bumps.BumpLeft,
+		bumpright: r.Bumps.BumpRight,
+	}
+}
+
+// streamSensors asks the bot to stream the telemetry packets every 15ms,
+// and sends each frame's readings to events. Nothing else may read from
+// the bot once the stream has started.
+func streamSensors(bot *roomba.Roomba, events chan<- window.Event, c clock.Clock) error {
+	if err := send(bot, oi.Stream{IDs: telemetryPackets}); err != nil {
+		return err
+	}
+	stream := sensors.NewStreamReader(bot.S)
+	go func() {
+		var bad int64
+		// This drains the frames until the connection closes, so it
+		// needs no done channel.
+		for f := range stream.Frames(nil) {
+			if stats := stream.Stats(); stats.BadFrames() > bad {
+				bad = stats.BadFrames()
+				log.Printf("Sensor stream: %d bad frames, %d bytes skipped", bad, stats.Skipped)
+			}
+			events <- &SensorEvent{c.Now(), newSensorInfo(&f.Readings)}
+		}
+		log.Printf("Sensor stream ended: %v", stream.Err())
+	}()
+	return nil
+}
+
+// robotAddr returns the address to reach the Roomba at, from -robot or else
+// from the older -testMode, -remote and -serial flags.
+func robotAddr() string {
+	switch {
+	case *robotURL != "":
+		return *robotURL
+	case *testMode == "true":
+		return "sim://" + *worldFile
+	case *remoteAddr != "":
+		return "tcp://" + *remoteAddr
+	default:
+		return "serial://" + *serialPort
 	}
-	roomba.S = conn
-	return roomba, nil
 }
 
 func makeRemotePi(remoteAddr string) (net.Conn, error) {
@@ -200,56 +240,21 @@ func makeRemotePi(remoteAddr string) (net.Conn, error) {
 	return conn, err
 }
 
-func defineSong(bot *roomba.Roomba, songNum int, songNotes []byte) {
-	songLen := len(songNotes) / 2
-	songBytes := []byte{byte(songNum - 1), byte(songLen)}
-	songBytes = append(songBytes, songNotes...)
-	bot.Write(140, songBytes)
-}
-
-func playSong(bot *roomba.Roomba, songNum int) {
-	songBytes := []byte{byte(songNum - 1)}
-	bot.Write(141, songBytes)
-}
-
-func sensorS8(bot *roomba.Roomba, sensor byte) (int, error) {
-	bytes, err := bot.Sensors(sensor)
-	if err != nil {
-		return 0, err
-	}
-	//log.Printf(" s8 bytes %v", bytes)
-	val := bytes[0]
-	return int(val), nil
-}
-
-func sensorS16(bot *roomba.Roomba, sensor byte) (int, error) {
-	bytes, err := bot.Sensors(sensor)
-	if err != nil {
-		return 0, err
-	}
-	//log.Printf(" s16 bytes %v", bytes)
-	val := binary.BigEndian.Uint16(bytes)
-	return int(val), nil
+// send writes OI commands to the bot, refusing any that are malformed.
+func send(bot *roomba.Roomba, cmds ...oi.Command) error {
+	return oi.NewEncoder(bot.S).Send(cmds...)
 }
 
-func sensorU8(bot *roomba.Roomba, sensor byte) (uint, error) {
-	bytes, err := bot.Sensors(sensor)
-	if err != nil {
-		return 0, err
+func defineSong(bot *roomba.Roomba, songNum int, songNotes []byte) error {
+	notes := make([]oi.Note, len(songNotes)/2)
+	for i := range notes {
+		notes[i] = oi.Note{Number: songNotes[2*i], Duration: songNotes[2*i+1]}
 	}
-	//log.Printf(" u8 bytes %v", bytes)
-	val := bytes[0]
-	return uint(val), nil
+	return send(bot, oi.Song{Num: byte(songNum - 1), Notes: notes})
 }
 
-func sensorU16(bot *roomba.Roomba, sensor byte) (uint, error) {
-	bytes, err := bot.Sensors(sensor)
-	if err != nil {
-		return 0, err
-	}
-	//log.Printf(" u16 bytes %v", bytes)
-	val := binary.BigEndian.Uint16(bytes)
-	return uint(val), nil
+func playSong(bot *roomba.Roomba, songNum int) error {
+	return send(bot, oi.Play{Num: byte(songNum - 1)})
 }
 
 // gfxLoop is responsible for drawing things to the window.
@@ -259,47 +264,38 @@ func gfxLoop(w window.Window, r gfx.Renderer) {
 	flag.Parse()
 
 	// Who we gonna call? Default to local serial unless a remote addr was given
-	var bot *roomba.Roomba
 	var pi net.Conn
 	var tilt int = 50
 
-	if *testMode == "true" {
-		log.Printf("Creating mock doombot")
-		bot = testing.MakeTestRoomba()
-
-	} else if *remoteAddr != "" {
-		log.Printf("Connecting to remote Doombot @ %s", *remoteAddr)
-		var err error
-		bot, err = makeRemoteRoomba(*remoteAddr)
-		if err != nil {
-			log.Fatalf("Connecting to remote Doombot @ %s failed", *remoteAddr)
-		}
-		pi, _ = makeRemotePi(*remoteAddr)
-
-	} else {
-		log.Printf("Connecting to local serial Doombot @ %s", *serialPort)
-		var err error
-		bot, err = roomba.MakeRoomba(*serialPort)
-		if err != nil {
-			log.Fatalf("Connecting to local serial Doombot @ %s failed", *serialPort)
-		}
+	addr := robotAddr()
+	log.Printf("Connecting to Doombot @ %s", addr)
+	tr, err := transport.Parse(addr)
+	if err != nil {
+		log.Fatalf("Bad Doombot address: %v", err)
+	}
+	robot, err := tr.Open()
+	if err != nil {
+		log.Fatalf("Connecting to Doombot @ %s failed: %v", tr, err)
+	}
+	bot := robot.Roomba
+	if t, ok := tr.(*transport.TCP); ok {
+		pi, _ = makeRemotePi(t.Addr)
 	}
 
 	// Start the Doombot & put it into Safe mode
 	log.Println()
 	log.Printf("Starting Doombot %s", bot.PortName)
-	err := bot.Start()
+	err = bot.Start()
 	if err != nil {
 		log.Fatal("Starting failed")
 	}
 
-	mode, _ := sensorU8(bot, 35)
-	log.Printf("Mode: %d", mode)
-	//log.Printf("Mode: %s", modes[mode])
-
-	// TODO - add sensor 21 to mock roomba
-	charging, _ := sensorU8(bot, 21)
-	log.Printf("Charging state: %d", charging)
+	state, err := sensors.Query(bot.S, sensors.PacketMode, sensors.PacketChargingState)
+	if err != nil {
+		log.Fatalf("Reading mode failed: %v", err)
+	}
+	log.Printf("Mode: %v", state.Mode)
+	log.Printf("Charging state: %v", state.ChargingState)
 	log.Println()
 
 	log.Printf("Entering Safe mode")
@@ -309,35 +305,32 @@ func gfxLoop(w window.Window, r gfx.Renderer) {
 	}
 	log.Println()
 
-	charging, _ = sensorU8(bot, 21)
-	log.Printf("Charging state: %d", charging)
-	/*
-		0 Not charging
-		1 Reconditioning Charging 2 Full Charging
-		3 Trickle Charging
-		4 Waiting
-		5 Charging Fault Condition
-	*/
+	state, err = sensors.Query(bot.S, sensors.PacketChargingState)
+	if err != nil {
+		log.Fatalf("Reading charging state failed: %v", err)
+	}
+	log.Printf("Charging state: %v", state.ChargingState)
 
-	// to be populated by a timer and dumped to the UI
-	sensor, _ := getSensorInfo(bot)
+	// to be updated from the sensor stream and dumped to the UI
+	sensor, err := getSensorInfo(bot)
+	if err != nil {
+		log.Fatalf("Reading sensors failed: %v", err)
+	}
 
 	// Create our events channel with sufficient buffer size.
 	events := make(chan window.Event, 256)
 
-	// collect sensor data in a separate goroutine
-	go func() {
-		for {
-			time.Sleep(500 * time.Millisecond)
-			events <- &TimeEvent{}
-		}
-	}()
+	// collect sensor data from the robot's stream
+	if err := streamSensors(bot, events, robot.Clock); err != nil {
+		log.Fatalf("Starting sensor stream failed: %v", err)
+	}
 
-	defineSong(bot, 1, cscaleup)
-	//defineSong(bot, 2, cscaledown)
-	defineSong(bot, 2, shaveandhaircut)
-	defineSong(bot, 3, silverscrapes)
-	defineSong(bot, 4, lacucaracha)
+	// cscaledown can stand in for shaveandhaircut as song 2.
+	for i, song := range [][]byte{cscaleup, shaveandhaircut, silverscrapes, lacucaracha} {
+		if err := defineSong(bot, i+1, song); err != nil {
+			log.Printf("Defining song %d failed: %v", i+1, err)
+		}
+	}
 
 	// Handle window events in a seperate goroutine
 	go func() {
@@ -353,6 +346,7 @@ func gfxLoop(w window.Window, r gfx.Renderer) {
 				log.Println()
 				log.Printf("Event type %s: %v", reflect.TypeOf(event), event)
 				ke := event.(keyboard.StateEvent)
+				var err error
 
 				motionChange := ke.Key == keyboard.ArrowUp || ke.Key == keyboard.ArrowDown ||
 					ke.Key == keyboard.ArrowLeft || ke.Key == keyboard.ArrowRight ||
@@ -383,7 +377,7 @@ func gfxLoop(w window.Window, r gfx.Renderer) {
 					vl := velocity - (rotation / 2)
 
 					log.Printf("Updating Right:%d Left:%d", vr, vl)
-					bot.DirectDrive(int16(vr), int16(vl))
+					err = send(bot, oi.DirectDrive{Right: int16(vr), Left: int16(vl)})
 
 				} else {
 					if w.Keyboard().Down(keyboard.R) {
@@ -396,7 +390,7 @@ func gfxLoop(w window.Window, r gfx.Renderer) {
 
 					} else if w.Keyboard().Down(keyboard.D) {
 						log.Printf("Seeking Dock")
-						err = bot.WriteByte(143) // Seek Dock
+						err = send(bot, oi.SeekDock{})
 
 					} else if w.Keyboard().Down(keyboard.W) {
 						log.Printf("Tilting up")
@@ -420,25 +414,30 @@ func gfxLoop(w window.Window, r gfx.Renderer) {
 
 					} else if w.Keyboard().Down(keyboard.One) {
 						log.Printf("Playing Song 1")
-						playSong(bot, 1)
+						err = playSong(bot, 1)
 					} else if w.Keyboard().Down(keyboard.Two) {
 						log.Printf("Playing Song 2")
-						playSong(bot, 2)
+						err = playSong(bot, 2)
 					} else if w.Keyboard().Down(keyboard.Three) {
 						log.Printf("Playing Song 3")
-						playSong(bot, 3)
+						err = playSong(bot, 3)
 					} else if w.Keyboard().Down(keyboard.Four) {
 						log.Printf("Playing Song 4")
-						playSong(bot, 4)
+						err = playSong(bot, 4)
 					} else if w.Keyboard().Down(keyboard.Five) {
 						log.Printf("Playing Song 5")
-						playSong(bot, 5)
+						err = playSong(bot, 5)
 					}
 				}
-			case *TimeEvent:
-				// grab all the sensor info
-				log.Printf("Getting latest sensor info")
-				sensor, _ = getSensorInfo(bot)
+				if err != nil {
+					log.Printf("Command failed: %v", err)
+				}
+			case *SensorEvent:
+				latest := event.(*SensorEvent).info
+				if latest.bumpleft != sensor.bumpleft || latest.bumpright != sensor.bumpright {
+					log.Printf("Bumps: left %t, right %t", latest.bumpleft, latest.bumpright)
+				}
+				sensor = latest
 			}
 		}
 	}()
@@ -533,9 +532,12 @@ func gfxLoop(w window.Window, r gfx.Renderer) {
 		if w.Keyboard().Down(keyboard.Q) {
 			log.Println()
 			log.Printf("Quitting")
-			bot.Stop()         // Motor Stop
-			bot.WriteByte(173) // Create 2 Stop
+			// Stop the motors, then the OI.
+			if err := send(bot, oi.Drive{}, oi.Stop{}); err != nil {
+				log.Printf("Stopping failed: %v", err)
+			}
 			//bot.Power()
+			robot.Close()
 
 			w.Close()
 		}
@@ -0,0 +1,143 @@
+/*
+Package clock abstracts the passing of time, so that the simulator and the
+code driving it can run on a virtual clock in tests. Real is the wall clock;
+a Manual clock only moves when it is told to, so that a scenario lasting
+half an hour can run in milliseconds and give the same result every time.
+*/
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and schedules timers.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the time once d has passed.
+	After(d time.Duration) <-chan time.Time
+	// NewTicker returns a ticker that ticks every d. Like time.Ticker, it
+	// drops ticks for a slow receiver.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks on a channel until it is stopped.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the wall clock.
+type Real struct{}
+
+func (Real) Now() time.Time                         { return time.Now() }
+func (Real) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (Real) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// Manual is a virtual clock that only moves when Advance or Set is called.
+// Timers and tickers fire, in time order, as the clock passes them.
+type Manual struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+// NewManual returns a virtual clock set to the given time.
+func NewManual(start time.Time) *Manual {
+	return &Manual{now: start}
+}
+
+type manualTimer struct {
+	at     time.Time
+	period time.Duration // zero for a one-shot timer
+	c      chan time.Time
+}
+
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *Manual) After(d time.Duration) <-chan time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := &manualTimer{at: m.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- m.now
+		return t.c
+	}
+	m.timers = append(m.timers, t)
+	return t.c
+}
+
+func (m *Manual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := &manualTimer{at: m.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	m.timers = append(m.timers, t)
+	return manualTicker{m, t}
+}
+
+// Advance moves the clock forward by d.
+func (m *Manual) Advance(d time.Duration) {
+	m.Set(m.Now().Add(d))
+}
+
+// Set moves the clock forward to t. The clock never goes backwards.
+func (m *Manual) Set(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		sort.Slice(m.timers, func(i, j int) bool {
+			return m.timers[i].at.Before(m.timers[j].at)
+		})
+		if len(m.timers) == 0 || m.timers[0].at.After(t) {
+			break
+		}
+		next := m.timers[0]
+		m.now = next.at
+		select {
+		case next.c <- next.at:
+		default:
+		}
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+		} else {
+			m.timers = m.timers[1:]
+		}
+	}
+	if t.After(m.now) {
+		m.now = t
+	}
+}
+
+func (m *Manual) remove(t *manualTimer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, other := range m.timers {
+		if other == t {
+			m.timers = append(m.timers[:i], m.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+type manualTicker struct {
+	m *Manual
+	t *manualTimer
+}
+
+func (t manualTicker) C() <-chan time.Time { return t.t.c }
+func (t manualTicker) Stop()               { t.m.remove(t.t) }
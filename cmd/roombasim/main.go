@@ -0,0 +1,169 @@
+package main
+
+/*
+Stand-in for a networked robot, so botcontrol's remote mode can be developed
+without a Roomba or a Pi. Serves the Open Interface over TCP on the port
+tcpserial uses, with a simulated robot for each client, and accepts
+picontrol's TILT commands on the port picontrol uses, logging them.
+
+	roombasim -world=sim/worlds/arena.json
+	botcontrol -remote=localhost:9003
+
+The clients' robots share the world, so two or more botcontrols can drive
+into each other.
+
+With -pty, the robot is served on a Linux pseudo-terminal instead of TCP, and
+the path to open is printed on startup. That exercises the real serial port
+code path:
+
+	roombasim -pty
+	botcontrol -serial=/dev/pts/N
+*/
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cquinn/doombot/sim"
+)
+
+const (
+	defaultPort   = 9003
+	defaultPiPort = 9004
+)
+
+var (
+	netPort   = flag.Int("port", defaultPort, "Network port to serve the simulated Roomba on")
+	piPort    = flag.Int("piport", defaultPiPort, "Network port to accept Pi tilt commands on")
+	worldFile = flag.String("world", "", "JSON world description for the simulated Roomba.")
+	usePty    = flag.Bool("pty", false, "Serve the simulated Roomba on a pseudo-terminal instead of TCP")
+)
+
+func main() {
+	flag.Parse()
+
+	var world *sim.World
+	if *worldFile != "" {
+		var err error
+		world, err = sim.LoadWorld(*worldFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	piLn, err := net.Listen("tcp", fmt.Sprintf(":%d", *piPort))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer piLn.Close()
+	go acceptLoop(piLn, handlePiConn)
+
+	if *usePty {
+		servePty(world)
+		return
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", *netPort))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ln.Close()
+	acceptLoop(ln, func(conn net.Conn) {
+		handleRoombaConn(conn, world)
+	})
+}
+
+// acceptLoop serves each connection on ln in its own goroutine, until the
+// listener is closed. Other errors, such as running out of file
+// descriptors, are retried with a growing delay.
+func acceptLoop(ln net.Listener, handle func(net.Conn)) {
+	var delay time.Duration
+	for {
+		log.Printf("Listening on: %s", ln.Addr())
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				log.Printf("Stopped listening on: %s", ln.Addr())
+				return
+			}
+			delay *= 2
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			}
+			if delay > time.Second {
+				delay = time.Second
+			}
+			log.Printf("Accept error: %v; retrying in %v", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		log.Printf("Connection on %s from %s", ln.Addr(), conn.RemoteAddr())
+		go handle(conn)
+	}
+}
+
+// handleRoombaConn runs a simulated Roomba for one client until it
+// disconnects. Each client gets its own robot, and they all share the one
+// world until they leave it.
+func handleRoombaConn(conn net.Conn, world *sim.World) {
+	defer conn.Close()
+	s := sim.ServeRoombaSim(conn)
+	if world != nil {
+		s.SetWorld(world)
+	}
+	<-s.Done()
+	s.SetWorld(nil)
+	if err := s.Err(); err != nil {
+		log.Printf("Roomba client %s failed: %v", conn.RemoteAddr(), err)
+	}
+	log.Printf("Roomba client %s disconnected", conn.RemoteAddr())
+}
+
+// servePty runs a simulated Roomba on a pseudo-terminal. Unlike over TCP,
+// successive clients share the one robot, as they would a real serial port.
+func servePty(world *sim.World) {
+	s, path, err := sim.ServeRoombaSimPty()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if world != nil {
+		s.SetWorld(world)
+	}
+	fmt.Println(path)
+	log.Printf("Serving simulated Roomba on: %s", path)
+	<-s.Done()
+}
+
+// handlePiConn accepts picontrol's line protocol and logs the tilts it asks
+// for.
+func handlePiConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		message, err := r.ReadString('\n')
+		message = strings.TrimSpace(strings.ToUpper(message))
+		if message != "" {
+			log.Printf("Got '%s'", message)
+		}
+		switch {
+		case strings.HasPrefix(message, "QUIT"):
+			return
+		case err != nil:
+			return
+		case strings.HasPrefix(message, "TILT"):
+			var tilt int
+			if _, err := fmt.Sscanf(message, "TILT %d", &tilt); err != nil {
+				log.Printf("Bad tilt command '%s': %v", message, err)
+				continue
+			}
+			log.Printf("Tilting to '%d'", tilt)
+		}
+	}
+}
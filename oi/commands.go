@@ -0,0 +1,320 @@
+/*
+Package oi describes the commands of the iRobot Create 2 Open Interface as
+typed values. An Encoder sends them, after checking their arguments are in
+range, and a Decoder reads them back from a byte stream.
+*/
+package oi
+
+import (
+	"fmt"
+	"math"
+)
+
+// Opcodes of the Open Interface commands.
+const (
+	OpReset          byte = 7
+	OpStart          byte = 128
+	OpBaud           byte = 129
+	OpControl        byte = 130
+	OpSafe           byte = 131
+	OpFull           byte = 132
+	OpPower          byte = 133
+	OpSpot           byte = 134
+	OpClean          byte = 135
+	OpMax            byte = 136
+	OpDrive          byte = 137
+	OpMotors         byte = 138
+	OpLEDs           byte = 139
+	OpSong           byte = 140
+	OpPlay           byte = 141
+	OpSensors        byte = 142
+	OpSeekDock       byte = 143
+	OpPWMMotors      byte = 144
+	OpDirectDrive    byte = 145
+	OpDrivePWM       byte = 146
+	OpStream         byte = 148
+	OpQueryList      byte = 149
+	OpPauseStream    byte = 150
+	OpScript         byte = 152
+	OpSchedulingLEDs byte = 162
+	OpDigitLEDsRaw   byte = 163
+	OpDigitLEDsASCII byte = 164
+	OpButtons        byte = 165
+	OpSchedule       byte = 167
+	OpSetDayTime     byte = 168
+	OpStop           byte = 173
+)
+
+// Command is a single Open Interface command.
+type Command interface {
+	Opcode() byte
+	String() string
+}
+
+type (
+	Reset    struct{}
+	Start    struct{}
+	Control  struct{}
+	Safe     struct{}
+	Full     struct{}
+	Power    struct{}
+	Spot     struct{}
+	Clean    struct{}
+	Max      struct{}
+	SeekDock struct{}
+	Stop     struct{}
+)
+
+func (Reset) Opcode() byte    { return OpReset }
+func (Start) Opcode() byte    { return OpStart }
+func (Control) Opcode() byte  { return OpControl }
+func (Safe) Opcode() byte     { return OpSafe }
+func (Full) Opcode() byte     { return OpFull }
+func (Power) Opcode() byte    { return OpPower }
+func (Spot) Opcode() byte     { return OpSpot }
+func (Clean) Opcode() byte    { return OpClean }
+func (Max) Opcode() byte      { return OpMax }
+func (SeekDock) Opcode() byte { return OpSeekDock }
+func (Stop) Opcode() byte     { return OpStop }
+
+func (Reset) String() string    { return "Reset" }
+func (Start) String() string    { return "Start" }
+func (Control) String() string  { return "Control" }
+func (Safe) String() string     { return "Safe" }
+func (Full) String() string     { return "Full" }
+func (Power) String() string    { return "Power" }
+func (Spot) String() string     { return "Spot" }
+func (Clean) String() string    { return "Clean" }
+func (Max) String() string      { return "Max" }
+func (SeekDock) String() string { return "SeekDock" }
+func (Stop) String() string     { return "Stop" }
+
+// Baud sets the baud rate, by code: 0 is 300 baud, up to 11 for 115200.
+type Baud struct {
+	Code byte
+}
+
+func (Baud) Opcode() byte     { return OpBaud }
+func (c Baud) String() string { return fmt.Sprintf("Baud(%d)", c.Code) }
+
+// Radii with special meanings in a Drive command.
+const (
+	Straight         int16 = math.MinInt16
+	StraightAlt      int16 = math.MaxInt16 // also means straight
+	TurnClockwise    int16 = -1
+	TurnCounterClock int16 = 1
+)
+
+// Drive drives at a velocity in mm/s around a turn of the given radius in
+// mm.
+type Drive struct {
+	Velocity, Radius int16
+}
+
+func (Drive) Opcode() byte { return OpDrive }
+
+// String shows the straight radius as 32768, as the OI spec writes it.
+func (c Drive) String() string {
+	if c.Radius == Straight {
+		return fmt.Sprintf("Drive(%d,32768)", c.Velocity)
+	}
+	return fmt.Sprintf("Drive(%d,%d)", c.Velocity, c.Radius)
+}
+
+// DirectDrive sets the velocity of each wheel in mm/s.
+type DirectDrive struct {
+	Right, Left int16
+}
+
+func (DirectDrive) Opcode() byte     { return OpDirectDrive }
+func (c DirectDrive) String() string { return fmt.Sprintf("DirectDrive(%d,%d)", c.Right, c.Left) }
+
+// DrivePWM sets the PWM duty cycle of each wheel, out of 255.
+type DrivePWM struct {
+	Right, Left int16
+}
+
+func (DrivePWM) Opcode() byte     { return OpDrivePWM }
+func (c DrivePWM) String() string { return fmt.Sprintf("DrivePWM(%d,%d)", c.Right, c.Left) }
+
+// Motors turns the cleaning motors on and off. See the OI spec for the
+// bits.
+type Motors struct {
+	Bits byte
+}
+
+func (Motors) Opcode() byte     { return OpMotors }
+func (c Motors) String() string { return fmt.Sprintf("Motors(%#02x)", c.Bits) }
+
+// PWMMotors sets the duty cycle of each cleaning motor, out of 128.
+type PWMMotors struct {
+	MainBrush, SideBrush int8
+	Vacuum               byte
+}
+
+func (PWMMotors) Opcode() byte { return OpPWMMotors }
+func (c PWMMotors) String() string {
+	return fmt.Sprintf("PWMMotors(%d,%d,%d)", c.MainBrush, c.SideBrush, c.Vacuum)
+}
+
+// LEDs sets the LEDs. See the OI spec for the bits.
+type LEDs struct {
+	Bits, PowerColor, PowerIntensity byte
+}
+
+func (LEDs) Opcode() byte { return OpLEDs }
+func (c LEDs) String() string {
+	return fmt.Sprintf("LEDs(%#02x,%d,%d)", c.Bits, c.PowerColor, c.PowerIntensity)
+}
+
+// SchedulingLEDs sets the scheduling LEDs.
+type SchedulingLEDs struct {
+	WeekdayBits, SchedulingBits byte
+}
+
+func (SchedulingLEDs) Opcode() byte { return OpSchedulingLEDs }
+func (c SchedulingLEDs) String() string {
+	return fmt.Sprintf("SchedulingLEDs(%#02x,%#02x)", c.WeekdayBits, c.SchedulingBits)
+}
+
+// DigitLEDsRaw sets the segments of the four digits, from left to right.
+type DigitLEDsRaw struct {
+	Digits [4]byte
+}
+
+func (DigitLEDsRaw) Opcode() byte { return OpDigitLEDsRaw }
+func (c DigitLEDsRaw) String() string {
+	return fmt.Sprintf("DigitLEDsRaw(%#02x,%#02x,%#02x,%#02x)",
+		c.Digits[0], c.Digits[1], c.Digits[2], c.Digits[3])
+}
+
+// DigitLEDsASCII shows four characters on the digits.
+type DigitLEDsASCII struct {
+	Chars [4]byte
+}
+
+func (DigitLEDsASCII) Opcode() byte     { return OpDigitLEDsASCII }
+func (c DigitLEDsASCII) String() string { return fmt.Sprintf("DigitLEDsASCII(%q)", c.Chars[:]) }
+
+// Buttons pushes buttons. See the OI spec for the bits.
+type Buttons struct {
+	Bits byte
+}
+
+func (Buttons) Opcode() byte     { return OpButtons }
+func (c Buttons) String() string { return fmt.Sprintf("Buttons(%#02x)", c.Bits) }
+
+// Note is a note of a song: a MIDI note number, and a duration in 64ths of
+// a second.
+type Note struct {
+	Number, Duration byte
+}
+
+// Song defines a song.
+type Song struct {
+	Num   byte
+	Notes []Note
+}
+
+func (Song) Opcode() byte { return OpSong }
+func (c Song) String() string {
+	notes := ""
+	for i, n := range c.Notes {
+		if i > 0 {
+			notes += " "
+		}
+		notes += fmt.Sprintf("%d/%d", n.Number, n.Duration)
+	}
+	return fmt.Sprintf("Song(%d,[%s])", c.Num, notes)
+}
+
+// Play plays a song.
+type Play struct {
+	Num byte
+}
+
+func (Play) Opcode() byte     { return OpPlay }
+func (c Play) String() string { return fmt.Sprintf("Play(%d)", c.Num) }
+
+// Sensors requests a sensor packet.
+type Sensors struct {
+	ID byte
+}
+
+func (Sensors) Opcode() byte     { return OpSensors }
+func (c Sensors) String() string { return fmt.Sprintf("Sensors(%d)", c.ID) }
+
+// QueryList requests a list of sensor packets.
+type QueryList struct {
+	IDs []byte
+}
+
+func (QueryList) Opcode() byte     { return OpQueryList }
+func (c QueryList) String() string { return fmt.Sprintf("QueryList(%v)", c.IDs) }
+
+// Stream starts streaming a list of sensor packets, or stops the stream if
+// the list is empty.
+type Stream struct {
+	IDs []byte
+}
+
+func (Stream) Opcode() byte     { return OpStream }
+func (c Stream) String() string { return fmt.Sprintf("Stream(%v)", c.IDs) }
+
+// PauseStream pauses or resumes the stream.
+type PauseStream struct {
+	Resume bool
+}
+
+func (PauseStream) Opcode() byte { return OpPauseStream }
+func (c PauseStream) String() string {
+	if c.Resume {
+		return "ResumeStream"
+	}
+	return "PauseStream"
+}
+
+// Script stores a list of commands on the robot, in place of any script it
+// had, as their bytes.
+type Script struct {
+	Commands []byte
+}
+
+func (Script) Opcode() byte     { return OpScript }
+func (c Script) String() string { return fmt.Sprintf("Script(%v)", c.Commands) }
+
+// Time is a time of day in a schedule.
+type Time struct {
+	Hour, Minute byte
+}
+
+// Schedule sets the cleaning schedule: the days to clean, as bits from
+// Sunday, and the time on each day from Sunday.
+type Schedule struct {
+	Days  byte
+	Times [7]Time
+}
+
+func (Schedule) Opcode() byte { return OpSchedule }
+func (c Schedule) String() string {
+	return fmt.Sprintf("Schedule(%#02x,%v)", c.Days, c.Times)
+}
+
+// SetDayTime sets the robot's clock. Day 0 is Sunday.
+type SetDayTime struct {
+	Day, Hour, Minute byte
+}
+
+func (SetDayTime) Opcode() byte { return OpSetDayTime }
+func (c SetDayTime) String() string {
+	return fmt.Sprintf("SetDayTime(%d,%02d:%02d)", c.Day, c.Hour, c.Minute)
+}
+
+// Unknown is a byte that isn't a known opcode. Since its length isn't
+// known, any arguments it has will decode as further commands.
+type Unknown struct {
+	Op byte
+}
+
+func (c Unknown) Opcode() byte   { return c.Op }
+func (c Unknown) String() string { return fmt.Sprintf("Unknown(%d)", c.Op) }
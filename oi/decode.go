@@ -0,0 +1,196 @@
+package oi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// argLengths gives the number of argument bytes of each fixed length
+// command.
+var argLengths = map[byte]int{
+	OpReset: 0, OpStart: 0, OpBaud: 1, OpControl: 0, OpSafe: 0, OpFull: 0,
+	OpPower: 0, OpSpot: 0, OpClean: 0, OpMax: 0, OpDrive: 4, OpMotors: 1,
+	OpLEDs: 3, OpPlay: 1, OpSensors: 1, OpSeekDock: 0, OpPWMMotors: 3,
+	OpDirectDrive: 4, OpDrivePWM: 4, OpPauseStream: 1, OpSchedulingLEDs: 2,
+	OpDigitLEDsRaw: 4, OpDigitLEDsASCII: 4, OpButtons: 1, OpSchedule: 15,
+	OpSetDayTime: 3, OpStop: 0,
+}
+
+// Decoder reads commands from an OI byte stream.
+type Decoder struct {
+	r *bufio.Reader
+}
+
+// NewDecoder returns a decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{bufio.NewReader(r)}
+}
+
+// Decode reads the next command. It returns io.EOF at the end of the
+// stream, and io.ErrUnexpectedEOF if the stream ends part way through a
+// command.
+func (d *Decoder) Decode() (Command, error) {
+	op, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	args, ok, err := ReadArgs(d.r, op)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return Unknown{op}, nil
+	}
+	return parse(op, args), nil
+}
+
+// ReadArgs reads the arguments of a command from r, given its opcode, which
+// has already been read. Song, Stream, Query List and Script commands give
+// their own lengths. ok is false if the opcode isn't known, and then nothing
+// is read. It returns io.ErrUnexpectedEOF if r ends part way through the
+// arguments.
+func ReadArgs(r io.Reader, op byte) (args []byte, ok bool, err error) {
+	switch op {
+	case OpSong:
+		header, err := read(r, 2)
+		if err != nil {
+			return nil, true, err
+		}
+		notes, err := read(r, 2*int(header[1]))
+		if err != nil {
+			return nil, true, err
+		}
+		return append(header, notes...), true, nil
+	case OpStream, OpQueryList, OpScript:
+		n, err := read(r, 1)
+		if err != nil {
+			return nil, true, err
+		}
+		rest, err := read(r, int(n[0]))
+		if err != nil {
+			return nil, true, err
+		}
+		return append(n, rest...), true, nil
+	}
+	n, ok := argLengths[op]
+	if !ok {
+		return nil, false, nil
+	}
+	args, err = read(r, n)
+	return args, true, err
+}
+
+func read(r io.Reader, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// DecodeAll decodes every command in b.
+func DecodeAll(b []byte) ([]Command, error) {
+	d := NewDecoder(bytes.NewReader(b))
+	var cmds []Command
+	for {
+		c, err := d.Decode()
+		if err == io.EOF {
+			return cmds, nil
+		}
+		if err != nil {
+			return cmds, fmt.Errorf("command %d: %v", len(cmds), err)
+		}
+		cmds = append(cmds, c)
+	}
+}
+
+// parse builds a command from its opcode and complete arguments.
+func parse(op byte, args []byte) Command {
+	switch op {
+	case OpReset:
+		return Reset{}
+	case OpStart:
+		return Start{}
+	case OpBaud:
+		return Baud{args[0]}
+	case OpControl:
+		return Control{}
+	case OpSafe:
+		return Safe{}
+	case OpFull:
+		return Full{}
+	case OpPower:
+		return Power{}
+	case OpSpot:
+		return Spot{}
+	case OpClean:
+		return Clean{}
+	case OpMax:
+		return Max{}
+	case OpDrive:
+		return Drive{s16(args[0:]), s16(args[2:])}
+	case OpMotors:
+		return Motors{args[0]}
+	case OpLEDs:
+		return LEDs{args[0], args[1], args[2]}
+	case OpSong:
+		song := Song{Num: args[0], Notes: make([]Note, args[1])}
+		for i := range song.Notes {
+			song.Notes[i] = Note{args[2+2*i], args[3+2*i]}
+		}
+		return song
+	case OpPlay:
+		return Play{args[0]}
+	case OpSensors:
+		return Sensors{args[0]}
+	case OpSeekDock:
+		return SeekDock{}
+	case OpPWMMotors:
+		return PWMMotors{int8(args[0]), int8(args[1]), args[2]}
+	case OpDirectDrive:
+		return DirectDrive{s16(args[0:]), s16(args[2:])}
+	case OpDrivePWM:
+		return DrivePWM{s16(args[0:]), s16(args[2:])}
+	case OpStream:
+		return Stream{args[1:]}
+	case OpQueryList:
+		return QueryList{args[1:]}
+	case OpPauseStream:
+		return PauseStream{args[0] != 0}
+	case OpScript:
+		return Script{args[1:]}
+	case OpSchedulingLEDs:
+		return SchedulingLEDs{args[0], args[1]}
+	case OpDigitLEDsRaw:
+		var c DigitLEDsRaw
+		copy(c.Digits[:], args)
+		return c
+	case OpDigitLEDsASCII:
+		var c DigitLEDsASCII
+		copy(c.Chars[:], args)
+		return c
+	case OpButtons:
+		return Buttons{args[0]}
+	case OpSchedule:
+		c := Schedule{Days: args[0]}
+		for i := range c.Times {
+			c.Times[i] = Time{args[1+2*i], args[2+2*i]}
+		}
+		return c
+	case OpSetDayTime:
+		return SetDayTime{args[0], args[1], args[2]}
+	case OpStop:
+		return Stop{}
+	}
+	return Unknown{op}
+}
+
+func s16(b []byte) int16 {
+	return int16(binary.BigEndian.Uint16(b))
+}
@@ -0,0 +1,255 @@
+package oi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/cquinn/doombot/sensors"
+)
+
+// Limits of the command arguments, from the OI spec.
+const (
+	MaxVelocity = 500  // mm/s, for Drive and DirectDrive
+	MaxRadius   = 2000 // mm, for Drive
+	MaxPWM      = 255  // for DrivePWM
+	MaxBrushPWM = 127  // for PWMMotors' brushes
+	MaxVacuum   = 127  // for PWMMotors
+	MaxBaudCode = 11
+	// The spec says the OI stores "up to four songs", but accepts song
+	// numbers 0-4, so there are five slots.
+	SongSlots = 5
+	MaxNotes  = 16
+	MaxScript = 100 // bytes of commands in a Script
+)
+
+// Encoder writes commands to an OI byte stream.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns an encoder writing to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w}
+}
+
+// Send encodes the commands and writes them in one go. If any of them is
+// invalid, nothing is written.
+func (e *Encoder) Send(cmds ...Command) error {
+	var buf []byte
+	for _, c := range cmds {
+		b, err := Encode(c)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, b...)
+	}
+	_, err := e.w.Write(buf)
+	return err
+}
+
+// Encode returns the bytes of a command, or an error if any of its
+// arguments is out of range.
+func Encode(c Command) ([]byte, error) {
+	args, err := encodeArgs(c)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", c, err)
+	}
+	return append([]byte{c.Opcode()}, args...), nil
+}
+
+func encodeArgs(c Command) ([]byte, error) {
+	switch c := c.(type) {
+	case Reset, Start, Control, Safe, Full, Power, Spot, Clean, Max, SeekDock, Stop:
+		return nil, nil
+	case Baud:
+		if c.Code > MaxBaudCode {
+			return nil, fmt.Errorf("baud code %d is over %d", c.Code, MaxBaudCode)
+		}
+		return []byte{c.Code}, nil
+	case Drive:
+		if err := inRange("velocity", int(c.Velocity), MaxVelocity); err != nil {
+			return nil, err
+		}
+		if c.Radius != Straight && c.Radius != StraightAlt {
+			if err := inRange("radius", int(c.Radius), MaxRadius); err != nil {
+				return nil, err
+			}
+		}
+		return pack16(c.Velocity, c.Radius), nil
+	case DirectDrive:
+		if err := inRange("right velocity", int(c.Right), MaxVelocity); err != nil {
+			return nil, err
+		}
+		if err := inRange("left velocity", int(c.Left), MaxVelocity); err != nil {
+			return nil, err
+		}
+		return pack16(c.Right, c.Left), nil
+	case DrivePWM:
+		if err := inRange("right PWM", int(c.Right), MaxPWM); err != nil {
+			return nil, err
+		}
+		if err := inRange("left PWM", int(c.Left), MaxPWM); err != nil {
+			return nil, err
+		}
+		return pack16(c.Right, c.Left), nil
+	case Motors:
+		if err := unusedBits(c.Bits, 0xe0); err != nil {
+			return nil, err
+		}
+		return []byte{c.Bits}, nil
+	case PWMMotors:
+		if err := inRange("main brush PWM", int(c.MainBrush), MaxBrushPWM); err != nil {
+			return nil, err
+		}
+		if err := inRange("side brush PWM", int(c.SideBrush), MaxBrushPWM); err != nil {
+			return nil, err
+		}
+		if c.Vacuum > MaxVacuum {
+			return nil, fmt.Errorf("vacuum PWM %d is over %d", c.Vacuum, MaxVacuum)
+		}
+		return []byte{byte(c.MainBrush), byte(c.SideBrush), c.Vacuum}, nil
+	case LEDs:
+		if err := unusedBits(c.Bits, 0xf0); err != nil {
+			return nil, err
+		}
+		return []byte{c.Bits, c.PowerColor, c.PowerIntensity}, nil
+	case SchedulingLEDs:
+		if err := unusedBits(c.WeekdayBits, 0x80); err != nil {
+			return nil, err
+		}
+		if err := unusedBits(c.SchedulingBits, 0xe0); err != nil {
+			return nil, err
+		}
+		return []byte{c.WeekdayBits, c.SchedulingBits}, nil
+	case DigitLEDsRaw:
+		for i, d := range c.Digits {
+			if d&0x80 != 0 {
+				return nil, fmt.Errorf("digit %d has bit 7 set, but only has 7 segments", i)
+			}
+		}
+		return c.Digits[:], nil
+	case DigitLEDsASCII:
+		for i, ch := range c.Chars {
+			if ch < 32 || ch > 126 {
+				return nil, fmt.Errorf("digit %d is %d, outside printable ASCII", i, ch)
+			}
+		}
+		return c.Chars[:], nil
+	case Buttons:
+		return []byte{c.Bits}, nil
+	case Song:
+		if err := songSlot(c.Num); err != nil {
+			return nil, err
+		}
+		if len(c.Notes) < 1 || len(c.Notes) > MaxNotes {
+			return nil, fmt.Errorf("song has %d notes, want 1 to %d", len(c.Notes), MaxNotes)
+		}
+		b := []byte{c.Num, byte(len(c.Notes))}
+		for _, n := range c.Notes {
+			b = append(b, n.Number, n.Duration)
+		}
+		return b, nil
+	case Play:
+		if err := songSlot(c.Num); err != nil {
+			return nil, err
+		}
+		return []byte{c.Num}, nil
+	case Sensors:
+		if err := knownPackets(c.ID); err != nil {
+			return nil, err
+		}
+		return []byte{c.ID}, nil
+	case QueryList:
+		if len(c.IDs) < 1 || len(c.IDs) > 255 {
+			return nil, fmt.Errorf("%d packets, want 1 to 255", len(c.IDs))
+		}
+		if err := knownPackets(c.IDs...); err != nil {
+			return nil, err
+		}
+		return append([]byte{byte(len(c.IDs))}, c.IDs...), nil
+	case Stream:
+		if len(c.IDs) > 255 {
+			return nil, fmt.Errorf("%d packets, want at most 255", len(c.IDs))
+		}
+		if err := knownPackets(c.IDs...); err != nil {
+			return nil, err
+		}
+		return append([]byte{byte(len(c.IDs))}, c.IDs...), nil
+	case PauseStream:
+		if c.Resume {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case Script:
+		if len(c.Commands) > MaxScript {
+			return nil, fmt.Errorf("script is %d bytes, want at most %d", len(c.Commands), MaxScript)
+		}
+		return append([]byte{byte(len(c.Commands))}, c.Commands...), nil
+	case Schedule:
+		if err := unusedBits(c.Days, 0x80); err != nil {
+			return nil, err
+		}
+		b := []byte{c.Days}
+		for _, t := range c.Times {
+			if err := timeOfDay(t.Hour, t.Minute); err != nil {
+				return nil, err
+			}
+			b = append(b, t.Hour, t.Minute)
+		}
+		return b, nil
+	case SetDayTime:
+		if c.Day > 6 {
+			return nil, fmt.Errorf("day %d is over 6", c.Day)
+		}
+		if err := timeOfDay(c.Hour, c.Minute); err != nil {
+			return nil, err
+		}
+		return []byte{c.Day, c.Hour, c.Minute}, nil
+	}
+	return nil, fmt.Errorf("can't encode unknown command")
+}
+
+func inRange(name string, v, max int) error {
+	if v < -max || v > max {
+		return fmt.Errorf("%s %d is outside -%d to %d", name, v, max, max)
+	}
+	return nil
+}
+
+func unusedBits(b, unused byte) error {
+	if b&unused != 0 {
+		return fmt.Errorf("bits %#02x are unused", b&unused)
+	}
+	return nil
+}
+
+func songSlot(n byte) error {
+	if n >= SongSlots {
+		return fmt.Errorf("song %d is outside 0 to %d", n, SongSlots-1)
+	}
+	return nil
+}
+
+func knownPackets(ids ...byte) error {
+	for _, id := range ids {
+		if _, err := sensors.Length(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func timeOfDay(hour, minute byte) error {
+	if hour > 23 || minute > 59 {
+		return fmt.Errorf("time %02d:%02d is not a time of day", hour, minute)
+	}
+	return nil
+}
+
+func pack16(a, b int16) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint16(buf, uint16(a))
+	binary.BigEndian.PutUint16(buf[2:], uint16(b))
+	return buf
+}
@@ -0,0 +1,171 @@
+package oi_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cquinn/doombot/oi"
+	simtesting "github.com/cquinn/doombot/testing"
+)
+
+func notes(n int) []oi.Note {
+	notes := make([]oi.Note, n)
+	for i := range notes {
+		notes[i] = oi.Note{Number: 60, Duration: 16}
+	}
+	return notes
+}
+
+// packets returns a list of n packet IDs, all of them the bumps packet.
+func packets(n int) []byte {
+	return bytes.Repeat([]byte{7}, n)
+}
+
+var encodeTests = []struct {
+	cmd oi.Command
+	ok  bool
+	// want is the encoding of a valid command, where it is worth spelling
+	// out. Every valid command is checked by decoding it again.
+	want []byte
+}{
+	{oi.Start{}, true, []byte{128}},
+	{oi.Stop{}, true, []byte{173}},
+
+	{oi.Baud{Code: oi.MaxBaudCode}, true, []byte{129, 11}},
+	{oi.Baud{Code: oi.MaxBaudCode + 1}, false, nil},
+
+	{oi.Drive{Velocity: 500, Radius: 0}, true, []byte{137, 0x01, 0xf4, 0, 0}},
+	{oi.Drive{Velocity: -500, Radius: 0}, true, []byte{137, 0xfe, 0x0c, 0, 0}},
+	{oi.Drive{Velocity: 501}, false, nil},
+	{oi.Drive{Velocity: -501}, false, nil},
+	{oi.Drive{Velocity: 200, Radius: oi.Straight}, true, []byte{137, 0, 200, 0x80, 0x00}},
+	{oi.Drive{Velocity: 200, Radius: oi.StraightAlt}, true, []byte{137, 0, 200, 0x7f, 0xff}},
+	{oi.Drive{Velocity: 200, Radius: 2000}, true, []byte{137, 0, 200, 0x07, 0xd0}},
+	{oi.Drive{Velocity: 200, Radius: -2000}, true, nil},
+	{oi.Drive{Velocity: 200, Radius: 2001}, false, nil},
+	{oi.Drive{Velocity: 200, Radius: -2001}, false, nil},
+	{oi.Drive{Velocity: 200, Radius: 32766}, false, nil},
+	{oi.Drive{Velocity: 200, Radius: oi.TurnClockwise}, true, []byte{137, 0, 200, 0xff, 0xff}},
+
+	{oi.DirectDrive{Right: 500, Left: -500}, true, []byte{145, 0x01, 0xf4, 0xfe, 0x0c}},
+	{oi.DirectDrive{Right: 501}, false, nil},
+	{oi.DirectDrive{Left: -501}, false, nil},
+
+	{oi.DrivePWM{Right: 255, Left: -255}, true, []byte{146, 0, 0xff, 0xff, 0x01}},
+	{oi.DrivePWM{Right: 256}, false, nil},
+	{oi.DrivePWM{Left: -256}, false, nil},
+
+	{oi.Motors{Bits: 0x1f}, true, []byte{138, 0x1f}},
+	{oi.Motors{Bits: 0x20}, false, nil},
+	{oi.PWMMotors{MainBrush: 127, SideBrush: -127, Vacuum: 127}, true, []byte{144, 127, 0x81, 127}},
+	{oi.PWMMotors{MainBrush: -128}, false, nil},
+	{oi.PWMMotors{SideBrush: -128}, false, nil},
+	{oi.PWMMotors{Vacuum: 128}, false, nil},
+
+	{oi.LEDs{Bits: 0x0f, PowerColor: 255, PowerIntensity: 255}, true, []byte{139, 0x0f, 255, 255}},
+	{oi.LEDs{Bits: 0x10}, false, nil},
+	{oi.SchedulingLEDs{WeekdayBits: 0x7f, SchedulingBits: 0x1f}, true, []byte{162, 0x7f, 0x1f}},
+	{oi.SchedulingLEDs{WeekdayBits: 0x80}, false, nil},
+	{oi.SchedulingLEDs{SchedulingBits: 0x20}, false, nil},
+	{oi.DigitLEDsRaw{Digits: [4]byte{0x7f, 0, 0, 0}}, true, []byte{163, 0x7f, 0, 0, 0}},
+	{oi.DigitLEDsRaw{Digits: [4]byte{0, 0, 0, 0x80}}, false, nil},
+	{oi.DigitLEDsASCII{Chars: [4]byte{' ', '~', 'A', '0'}}, true, []byte{164, 32, 126, 65, 48}},
+	{oi.DigitLEDsASCII{Chars: [4]byte{31, 'A', 'A', 'A'}}, false, nil},
+	{oi.DigitLEDsASCII{Chars: [4]byte{'A', 'A', 'A', 127}}, false, nil},
+	{oi.Buttons{Bits: 0xff}, true, []byte{165, 0xff}},
+
+	{oi.Song{Num: 0, Notes: notes(oi.MaxNotes)}, true, nil},
+	{oi.Song{Num: 0, Notes: notes(oi.MaxNotes + 1)}, false, nil},
+	{oi.Song{Num: 0, Notes: nil}, false, nil},
+	{oi.Song{Num: oi.SongSlots - 1, Notes: notes(1)}, true, []byte{140, 4, 1, 60, 16}},
+	{oi.Song{Num: oi.SongSlots, Notes: notes(1)}, false, nil},
+	{oi.Play{Num: oi.SongSlots - 1}, true, []byte{141, 4}},
+	{oi.Play{Num: oi.SongSlots}, false, nil},
+
+	{oi.Sensors{ID: 58}, true, []byte{142, 58}},
+	{oi.Sensors{ID: 107}, true, []byte{142, 107}},
+	{oi.Sensors{ID: 59}, false, nil},
+	{oi.QueryList{IDs: []byte{7, 35}}, true, []byte{149, 2, 7, 35}},
+	{oi.QueryList{IDs: packets(255)}, true, nil},
+	{oi.QueryList{IDs: packets(256)}, false, nil},
+	{oi.QueryList{}, false, nil},
+	{oi.QueryList{IDs: []byte{7, 200}}, false, nil},
+	{oi.Stream{}, true, []byte{148, 0}},
+	{oi.Stream{IDs: []byte{100}}, true, []byte{148, 1, 100}},
+	{oi.Stream{IDs: packets(256)}, false, nil},
+	{oi.Stream{IDs: []byte{8, 0, 59}}, false, nil},
+	{oi.PauseStream{Resume: true}, true, []byte{150, 1}},
+	{oi.PauseStream{Resume: false}, true, []byte{150, 0}},
+
+	{oi.Script{Commands: []byte{137, 0, 200, 0x80, 0}}, true, []byte{152, 5, 137, 0, 200, 0x80, 0}},
+	{oi.Script{Commands: bytes.Repeat([]byte{128}, oi.MaxScript)}, true, nil},
+	{oi.Script{Commands: bytes.Repeat([]byte{128}, oi.MaxScript+1)}, false, nil},
+
+	{oi.Schedule{Days: 0x7f, Times: [7]oi.Time{{Hour: 23, Minute: 59}}}, true, nil},
+	{oi.Schedule{Days: 0x80}, false, nil},
+	{oi.Schedule{Times: [7]oi.Time{6: {Hour: 24}}}, false, nil},
+	{oi.SetDayTime{Day: 6, Hour: 23, Minute: 59}, true, []byte{168, 6, 23, 59}},
+	{oi.SetDayTime{Day: 7}, false, nil},
+	{oi.SetDayTime{Hour: 24}, false, nil},
+	{oi.SetDayTime{Minute: 60}, false, nil},
+
+	{oi.Unknown{Op: 3}, false, nil},
+}
+
+func TestEncode(t *testing.T) {
+	for _, test := range encodeTests {
+		b, err := oi.Encode(test.cmd)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%v: encoded as % d, want an error", test.cmd, b)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.cmd, err)
+			continue
+		}
+		if test.want != nil && !bytes.Equal(b, test.want) {
+			t.Errorf("%v: encoded as % d, want % d", test.cmd, b, test.want)
+		}
+		decoded, err := oi.DecodeAll(b)
+		if err != nil || len(decoded) != 1 || decoded[0].String() != test.cmd.String() {
+			t.Errorf("%v: decoded as %v, %v", test.cmd, decoded, err)
+		}
+	}
+}
+
+func TestSendWritesNothingIfAnyCommandIsBad(t *testing.T) {
+	for _, test := range encodeTests {
+		if test.ok {
+			continue
+		}
+		var buf bytes.Buffer
+		err := oi.NewEncoder(&buf).Send(oi.Start{}, oi.Safe{}, test.cmd, oi.Stop{})
+		if err == nil {
+			t.Errorf("%v: sent without error", test.cmd)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%v: wrote % d, want nothing", test.cmd, buf.Bytes())
+		}
+	}
+}
+
+func TestSendToSimulator(t *testing.T) {
+	r := simtesting.NewTestRoomba(t, simtesting.Config{})
+	cmds := []oi.Command{
+		oi.Start{},
+		oi.Safe{},
+		oi.Song{Num: 0, Notes: notes(3)},
+		oi.Play{Num: 0},
+		oi.Drive{Velocity: -500, Radius: oi.Straight},
+		oi.DirectDrive{Right: 500, Left: 500},
+		oi.Drive{},
+	}
+	if err := oi.NewEncoder(r.S).Send(cmds...); err != nil {
+		t.Fatal(err)
+	}
+	r.VerifyCommands(cmds, t)
+	r.VerifyWritten([]byte{128, 131, 140, 0, 3, 60, 16, 60, 16, 60, 16, 141, 0}, t)
+	r.VerifyNoProtocolErrors(t)
+}
@@ -0,0 +1,307 @@
+/*
+Package sensors decodes the Create 2's sensor packets into typed values.
+
+Each single packet, 7 to 58, has a field in Readings, with the signedness
+and units given in the OI spec. Group packets such as 6 and 100 decode into
+all the fields of the packets they contain.
+*/
+package sensors
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// opQueryList is the Query List command's opcode. It is oi.OpQueryList, but
+// oi depends on this package to check the packets commands ask for, so it
+// can't be imported here.
+const opQueryList byte = 149
+
+// IDs of the single sensor packets. 16, 32 and 33 are unused.
+const (
+	PacketBumps                  byte = 7
+	PacketWall                   byte = 8
+	PacketCliffLeft              byte = 9
+	PacketCliffFrontLeft         byte = 10
+	PacketCliffFrontRight        byte = 11
+	PacketCliffRight             byte = 12
+	PacketVirtualWall            byte = 13
+	PacketOvercurrents           byte = 14
+	PacketDirtDetect             byte = 15
+	PacketIROmni                 byte = 17
+	PacketButtons                byte = 18
+	PacketDistance               byte = 19
+	PacketAngle                  byte = 20
+	PacketChargingState          byte = 21
+	PacketVoltage                byte = 22
+	PacketCurrent                byte = 23
+	PacketTemperature            byte = 24
+	PacketBatteryCharge          byte = 25
+	PacketBatteryCapacity        byte = 26
+	PacketWallSignal             byte = 27
+	PacketCliffLeftSignal        byte = 28
+	PacketCliffFrontLeftSignal   byte = 29
+	PacketCliffFrontRightSignal  byte = 30
+	PacketCliffRightSignal       byte = 31
+	PacketChargingSources        byte = 34
+	PacketMode                   byte = 35
+	PacketSongNumber             byte = 36
+	PacketSongPlaying            byte = 37
+	PacketStreamPackets          byte = 38
+	PacketRequestedVelocity      byte = 39
+	PacketRequestedRadius        byte = 40
+	PacketRequestedRightVelocity byte = 41
+	PacketRequestedLeftVelocity  byte = 42
+	PacketLeftEncoderCounts      byte = 43
+	PacketRightEncoderCounts     byte = 44
+	PacketLightBumper            byte = 45
+	PacketLightBumpLeft          byte = 46
+	PacketLightBumpFrontLeft     byte = 47
+	PacketLightBumpCenterLeft    byte = 48
+	PacketLightBumpCenterRight   byte = 49
+	PacketLightBumpFrontRight    byte = 50
+	PacketLightBumpRight         byte = 51
+	PacketIRLeft                 byte = 52
+	PacketIRRight                byte = 53
+	PacketLeftMotorCurrent       byte = 54
+	PacketRightMotorCurrent      byte = 55
+	PacketMainBrushMotorCurrent  byte = 56
+	PacketSideBrushMotorCurrent  byte = 57
+	PacketStasis                 byte = 58
+)
+
+// Readings holds the values of the sensor packets. Distances are in mm,
+// angles in degrees, velocities in mm/s, voltages in mV, currents in mA,
+// charge in mAh and temperatures in degrees C.
+type Readings struct {
+	Bumps           Bumps
+	Wall            bool
+	CliffLeft       bool
+	CliffFrontLeft  bool
+	CliffFrontRight bool
+	CliffRight      bool
+	VirtualWall     bool
+	Overcurrents    Overcurrents
+	DirtDetect      byte
+	IROmni          byte
+	Buttons         Buttons
+	Distance        int16 // since it was last read
+	Angle           int16 // since it was last read, counter-clockwise
+	ChargingState   ChargingState
+	Voltage         uint16
+	Current         int16 // negative when discharging
+	Temperature     int8
+	BatteryCharge   uint16
+	BatteryCapacity uint16
+
+	WallSignal            uint16
+	CliffLeftSignal       uint16
+	CliffFrontLeftSignal  uint16
+	CliffFrontRightSignal uint16
+	CliffRightSignal      uint16
+	ChargingSources       ChargingSources
+	Mode                  Mode
+	SongNumber            byte
+	SongPlaying           bool
+	StreamPackets         byte
+
+	RequestedVelocity      int16
+	RequestedRadius        int16
+	RequestedRightVelocity int16
+	RequestedLeftVelocity  int16
+	LeftEncoderCounts      uint16
+	RightEncoderCounts     uint16
+
+	LightBumper                LightBumper
+	LightBumpLeftSignal        uint16
+	LightBumpFrontLeftSignal   uint16
+	LightBumpCenterLeftSignal  uint16
+	LightBumpCenterRightSignal uint16
+	LightBumpFrontRightSignal  uint16
+	LightBumpRightSignal       uint16
+
+	IRLeft                byte
+	IRRight               byte
+	LeftMotorCurrent      int16
+	RightMotorCurrent     int16
+	MainBrushMotorCurrent int16
+	SideBrushMotorCurrent int16
+	Stasis                bool
+}
+
+// packet describes how to decode a single sensor packet into Readings.
+type packet struct {
+	length int
+	decode func(r *Readings, b []byte)
+}
+
+func u16(b []byte) uint16 { return binary.BigEndian.Uint16(b) }
+func s16(b []byte) int16  { return int16(binary.BigEndian.Uint16(b)) }
+
+var packets = map[byte]packet{
+	PacketBumps:           {1, func(r *Readings, b []byte) { r.Bumps = decodeBumps(b[0]) }},
+	PacketWall:            {1, func(r *Readings, b []byte) { r.Wall = b[0] != 0 }},
+	PacketCliffLeft:       {1, func(r *Readings, b []byte) { r.CliffLeft = b[0] != 0 }},
+	PacketCliffFrontLeft:  {1, func(r *Readings, b []byte) { r.CliffFrontLeft = b[0] != 0 }},
+	PacketCliffFrontRight: {1, func(r *Readings, b []byte) { r.CliffFrontRight = b[0] != 0 }},
+	PacketCliffRight:      {1, func(r *Readings, b []byte) { r.CliffRight = b[0] != 0 }},
+	PacketVirtualWall:     {1, func(r *Readings, b []byte) { r.VirtualWall = b[0] != 0 }},
+	PacketOvercurrents:    {1, func(r *Readings, b []byte) { r.Overcurrents = decodeOvercurrents(b[0]) }},
+	PacketDirtDetect:      {1, func(r *Readings, b []byte) { r.DirtDetect = b[0] }},
+	16:                    {1, func(r *Readings, b []byte) {}},
+	PacketIROmni:          {1, func(r *Readings, b []byte) { r.IROmni = b[0] }},
+	PacketButtons:         {1, func(r *Readings, b []byte) { r.Buttons = decodeButtons(b[0]) }},
+	PacketDistance:        {2, func(r *Readings, b []byte) { r.Distance = s16(b) }},
+	PacketAngle:           {2, func(r *Readings, b []byte) { r.Angle = s16(b) }},
+	PacketChargingState:   {1, func(r *Readings, b []byte) { r.ChargingState = ChargingState(b[0]) }},
+	PacketVoltage:         {2, func(r *Readings, b []byte) { r.Voltage = u16(b) }},
+	PacketCurrent:         {2, func(r *Readings, b []byte) { r.Current = s16(b) }},
+	PacketTemperature:     {1, func(r *Readings, b []byte) { r.Temperature = int8(b[0]) }},
+	PacketBatteryCharge:   {2, func(r *Readings, b []byte) { r.BatteryCharge = u16(b) }},
+	PacketBatteryCapacity: {2, func(r *Readings, b []byte) { r.BatteryCapacity = u16(b) }},
+
+	PacketWallSignal:            {2, func(r *Readings, b []byte) { r.WallSignal = u16(b) }},
+	PacketCliffLeftSignal:       {2, func(r *Readings, b []byte) { r.CliffLeftSignal = u16(b) }},
+	PacketCliffFrontLeftSignal:  {2, func(r *Readings, b []byte) { r.CliffFrontLeftSignal = u16(b) }},
+	PacketCliffFrontRightSignal: {2, func(r *Readings, b []byte) { r.CliffFrontRightSignal = u16(b) }},
+	PacketCliffRightSignal:      {2, func(r *Readings, b []byte) { r.CliffRightSignal = u16(b) }},
+	32:                          {1, func(r *Readings, b []byte) {}},
+	33:                          {2, func(r *Readings, b []byte) {}},
+	PacketChargingSources:       {1, func(r *Readings, b []byte) { r.ChargingSources = decodeChargingSources(b[0]) }},
+	PacketMode:                  {1, func(r *Readings, b []byte) { r.Mode = Mode(b[0]) }},
+	PacketSongNumber:            {1, func(r *Readings, b []byte) { r.SongNumber = b[0] }},
+	PacketSongPlaying:           {1, func(r *Readings, b []byte) { r.SongPlaying = b[0] != 0 }},
+	PacketStreamPackets:         {1, func(r *Readings, b []byte) { r.StreamPackets = b[0] }},
+
+	PacketRequestedVelocity:      {2, func(r *Readings, b []byte) { r.RequestedVelocity = s16(b) }},
+	PacketRequestedRadius:        {2, func(r *Readings, b []byte) { r.RequestedRadius = s16(b) }},
+	PacketRequestedRightVelocity: {2, func(r *Readings, b []byte) { r.RequestedRightVelocity = s16(b) }},
+	PacketRequestedLeftVelocity:  {2, func(r *Readings, b []byte) { r.RequestedLeftVelocity = s16(b) }},
+	PacketLeftEncoderCounts:      {2, func(r *Readings, b []byte) { r.LeftEncoderCounts = u16(b) }},
+	PacketRightEncoderCounts:     {2, func(r *Readings, b []byte) { r.RightEncoderCounts = u16(b) }},
+
+	PacketLightBumper:          {1, func(r *Readings, b []byte) { r.LightBumper = decodeLightBumper(b[0]) }},
+	PacketLightBumpLeft:        {2, func(r *Readings, b []byte) { r.LightBumpLeftSignal = u16(b) }},
+	PacketLightBumpFrontLeft:   {2, func(r *Readings, b []byte) { r.LightBumpFrontLeftSignal = u16(b) }},
+	PacketLightBumpCenterLeft:  {2, func(r *Readings, b []byte) { r.LightBumpCenterLeftSignal = u16(b) }},
+	PacketLightBumpCenterRight: {2, func(r *Readings, b []byte) { r.LightBumpCenterRightSignal = u16(b) }},
+	PacketLightBumpFrontRight:  {2, func(r *Readings, b []byte) { r.LightBumpFrontRightSignal = u16(b) }},
+	PacketLightBumpRight:       {2, func(r *Readings, b []byte) { r.LightBumpRightSignal = u16(b) }},
+
+	PacketIRLeft:                {1, func(r *Readings, b []byte) { r.IRLeft = b[0] }},
+	PacketIRRight:               {1, func(r *Readings, b []byte) { r.IRRight = b[0] }},
+	PacketLeftMotorCurrent:      {2, func(r *Readings, b []byte) { r.LeftMotorCurrent = s16(b) }},
+	PacketRightMotorCurrent:     {2, func(r *Readings, b []byte) { r.RightMotorCurrent = s16(b) }},
+	PacketMainBrushMotorCurrent: {2, func(r *Readings, b []byte) { r.MainBrushMotorCurrent = s16(b) }},
+	PacketSideBrushMotorCurrent: {2, func(r *Readings, b []byte) { r.SideBrushMotorCurrent = s16(b) }},
+	PacketStasis:                {1, func(r *Readings, b []byte) { r.Stasis = b[0] != 0 }},
+}
+
+// groups gives the first and last single packets in each group packet.
+var groups = map[byte][2]byte{
+	0:   {7, 26},
+	1:   {7, 16},
+	2:   {17, 20},
+	3:   {21, 26},
+	4:   {27, 34},
+	5:   {35, 42},
+	6:   {7, 42},
+	100: {7, 58},
+	101: {43, 58},
+	106: {46, 51},
+	107: {54, 58},
+}
+
+// Members returns the single packets that make up a group packet, in the
+// order their data is sent, and false if id isn't a group packet.
+func Members(id byte) ([]byte, bool) {
+	g, ok := groups[id]
+	if !ok {
+		return nil, false
+	}
+	ids := make([]byte, 0, g[1]-g[0]+1)
+	for i := g[0]; i <= g[1]; i++ {
+		ids = append(ids, i)
+	}
+	return ids, true
+}
+
+// Length returns the number of data bytes in a sensor packet, single or
+// group.
+func Length(id byte) (int, error) {
+	if p, ok := packets[id]; ok {
+		return p.length, nil
+	}
+	g, ok := groups[id]
+	if !ok {
+		return 0, fmt.Errorf("unknown sensor packet %d", id)
+	}
+	n := 0
+	for i := g[0]; i <= g[1]; i++ {
+		n += packets[i].length
+	}
+	return n, nil
+}
+
+// Decode decodes the data of a sensor packet, single or group, into the
+// readings, leaving the fields of other packets alone.
+func (r *Readings) Decode(id byte, data []byte) error {
+	n, err := Length(id)
+	if err != nil {
+		return err
+	}
+	if len(data) != n {
+		return fmt.Errorf("sensor packet %d: got %d bytes, want %d", id, len(data), n)
+	}
+	if p, ok := packets[id]; ok {
+		p.decode(r, data)
+		return nil
+	}
+	g := groups[id]
+	for i := g[0]; i <= g[1]; i++ {
+		p := packets[i]
+		p.decode(r, data[:p.length])
+		data = data[p.length:]
+	}
+	return nil
+}
+
+// Decode decodes the data of a sensor packet into new readings.
+func Decode(id byte, data []byte) (*Readings, error) {
+	r := &Readings{}
+	if err := r.Decode(id, data); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Query asks the robot on rw for the given packets with the Query List
+// command, and decodes its reply.
+func Query(rw io.ReadWriter, ids ...byte) (*Readings, error) {
+	total := 0
+	for _, id := range ids {
+		n, err := Length(id)
+		if err != nil {
+			return nil, err
+		}
+		total += n
+	}
+	cmd := append([]byte{opQueryList, byte(len(ids))}, ids...)
+	if _, err := rw.Write(cmd); err != nil {
+		return nil, fmt.Errorf("querying sensors %v: %v", ids, err)
+	}
+	data := make([]byte, total)
+	if _, err := io.ReadFull(rw, data); err != nil {
+		return nil, fmt.Errorf("reading sensors %v: %v", ids, err)
+	}
+	r := &Readings{}
+	for _, id := range ids {
+		n, _ := Length(id)
+		if err := r.Decode(id, data[:n]); err != nil {
+			return nil, err
+		}
+		data = data[n:]
+	}
+	return r, nil
+}
@@ -0,0 +1,190 @@
+package sensors
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// StreamHeader starts every frame of a sensor stream.
+const StreamHeader byte = 19
+
+// Frame is a decoded frame of a sensor stream: the readings of the packets
+// it contained, which are listed in IDs.
+type Frame struct {
+	IDs []byte
+	Readings
+}
+
+// StreamStats counts what a StreamReader has read.
+type StreamStats struct {
+	Frames       int64 // good frames
+	BadChecksums int64 // frames whose checksum didn't match
+	Malformed    int64 // frames whose checksum matched but whose packets didn't parse
+	Truncated    int64 // frames cut short by the end of the stream
+	Skipped      int64 // bytes thrown away looking for the start of a frame
+}
+
+// BadFrames is the number of frames that were thrown away.
+func (s StreamStats) BadFrames() int64 {
+	return s.BadChecksums + s.Malformed + s.Truncated
+}
+
+// StreamReader reads the frames of the sensor stream started by the Stream
+// command, from any connection to the robot: a serial port, a TCP socket to
+// tcpserial, or the simulator's pipe. A frame is the header, the number of
+// bytes that follow before the checksum, each packet ID followed by its
+// data, and a checksum that makes the low byte of the frame's sum zero.
+//
+// The reader checks each frame's checksum and contents. After garbage, a
+// bad frame or dropped bytes, it resynchronizes by looking for the next
+// header, which may be part way through what looked like a frame.
+type StreamReader struct {
+	r *bufio.Reader
+
+	mu    sync.Mutex
+	stats StreamStats
+	err   error
+}
+
+// NewStreamReader returns a reader of the sensor stream on r.
+func NewStreamReader(r io.Reader) *StreamReader {
+	return &StreamReader{r: bufio.NewReader(r)}
+}
+
+// Next reads the next good frame. It returns io.EOF at the end of the
+// stream, and io.ErrUnexpectedEOF if the stream ends part way through a
+// frame.
+func (s *StreamReader) Next() (Frame, error) {
+	// Whether the last header seen was cut short by the end of the stream.
+	truncated := false
+	for {
+		if err := s.findHeader(); err != nil {
+			if err == io.EOF && truncated {
+				err = io.ErrUnexpectedEOF
+			}
+			return Frame{}, err
+		}
+		truncated = false
+		frame, err := s.r.Peek(2)
+		if err == nil {
+			frame, err = s.r.Peek(int(frame[1]) + 3)
+		}
+		if err == io.EOF {
+			// The stream ends too soon for this to be a whole frame,
+			// but the header may have been a stray, with a whole frame
+			// after it.
+			s.count(func(st *StreamStats) { st.Truncated++ })
+			s.r.Discard(1)
+			truncated = true
+			continue
+		}
+		if err != nil {
+			return Frame{}, err
+		}
+
+		var sum byte
+		for _, b := range frame {
+			sum += b
+		}
+		if sum != 0 {
+			s.count(func(st *StreamStats) { st.BadChecksums++ })
+			s.r.Discard(1)
+			continue
+		}
+		f, err := decodePackets(frame[2 : len(frame)-1])
+		if err != nil {
+			s.count(func(st *StreamStats) { st.Malformed++ })
+			s.r.Discard(1)
+			continue
+		}
+		s.r.Discard(len(frame))
+		s.count(func(st *StreamStats) { st.Frames++ })
+		return f, nil
+	}
+}
+
+// findHeader discards bytes up to the next frame header.
+func (s *StreamReader) findHeader() error {
+	for {
+		b, err := s.r.Peek(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == StreamHeader {
+			return nil
+		}
+		s.r.Discard(1)
+		s.count(func(st *StreamStats) { st.Skipped++ })
+	}
+}
+
+func (s *StreamReader) count(f func(*StreamStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(&s.stats)
+}
+
+// Stats returns the counts of frames read and thrown away so far.
+func (s *StreamReader) Stats() StreamStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Frames reads frames in a goroutine and publishes them on the returned
+// channel, which is closed when reading fails; Err then says why. Closing
+// done stops the goroutine once it has read its current frame, and
+// closing the connection stops it straight away. Either the channel must
+// be drained or done closed, or the goroutine blocks for good once the
+// channel's buffer fills. Don't call Next as well.
+func (s *StreamReader) Frames(done <-chan struct{}) <-chan Frame {
+	frames := make(chan Frame, 16)
+	go func() {
+		defer close(frames)
+		for {
+			f, err := s.Next()
+			if err != nil {
+				s.mu.Lock()
+				s.err = err
+				s.mu.Unlock()
+				return
+			}
+			select {
+			case frames <- f:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return frames
+}
+
+// Err returns the error that closed the Frames channel.
+func (s *StreamReader) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// decodePackets decodes a run of packet IDs each followed by its data.
+func decodePackets(data []byte) (Frame, error) {
+	var f Frame
+	for len(data) > 0 {
+		id := data[0]
+		n, err := Length(id)
+		if err != nil {
+			return Frame{}, err
+		}
+		if len(data) < 1+n {
+			return Frame{}, fmt.Errorf("sensor packet %d: got %d bytes, want %d", id, len(data)-1, n)
+		}
+		if err := f.Decode(id, data[1:1+n]); err != nil {
+			return Frame{}, err
+		}
+		f.IDs = append(f.IDs, id)
+		data = data[1+n:]
+	}
+	return f, nil
+}
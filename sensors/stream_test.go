@@ -0,0 +1,142 @@
+package sensors_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+	simtesting "github.com/cquinn/doombot/testing"
+)
+
+// streamPackets are the packets in the test frames: bumps, voltage, current
+// and mode, 10 bytes with their IDs.
+var streamPackets = []byte{
+	sensors.PacketBumps, sensors.PacketVoltage, sensors.PacketCurrent, sensors.PacketMode,
+}
+
+// simFrame returns a frame of streamPackets as the simulator sends it in
+// answer to the Stream command, with the given voltage.
+func simFrame(t *testing.T, voltage []byte) []byte {
+	r := simtesting.NewTestRoomba(t, simtesting.Config{
+		Sensors: map[byte][]byte{
+			sensors.PacketVoltage: voltage,
+			sensors.PacketCurrent: {0xfe, 0x70}, // -400mA
+		},
+	})
+	if err := oi.NewEncoder(r.S).Send(oi.Start{}, oi.Stream{IDs: streamPackets}); err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, 13)
+	if _, err := io.ReadFull(r.S, frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame[0] != sensors.StreamHeader || frame[1] != 10 {
+		t.Fatalf("simulator sent % d, want a frame of 10 bytes", frame)
+	}
+	return frame
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestStreamReader(t *testing.T) {
+	// A frame with no 19s after its header, whose checksum is 85.
+	f := simFrame(t, []byte{60, 140})
+	// A frame with a 19 in its voltage, whose checksum is 10.
+	g := simFrame(t, []byte{0, 19})
+
+	badChecksum := join(f[:12], []byte{f[12] + 1})
+	dropped := join(f[:5], f[6:])
+	malformed := []byte{sensors.StreamHeader, 2, 200, 0, 35} // packet 200
+
+	tests := []struct {
+		name   string
+		stream []byte
+		frames int
+		err    error
+		stats  sensors.StreamStats
+	}{
+		{"clean", join(f, f, f), 3, io.EOF,
+			sensors.StreamStats{Frames: 3}},
+		{"leading garbage", join([]byte{0, 1, 2, 0xff}, f, f), 2, io.EOF,
+			sensors.StreamStats{Frames: 2, Skipped: 4}},
+		{"stray header in garbage", join([]byte{19, 2, 7}, f, f), 2, io.EOF,
+			sensors.StreamStats{Frames: 2, BadChecksums: 1, Skipped: 2}},
+		// With its header lost, g's voltage looks like a header.
+		{"stray header in data", join(g[1:], f, f), 2, io.EOF,
+			sensors.StreamStats{Frames: 2, BadChecksums: 1, Skipped: 11}},
+		{"bad checksum", join(badChecksum, f), 1, io.EOF,
+			sensors.StreamStats{Frames: 1, BadChecksums: 1, Skipped: 12}},
+		{"dropped byte", join(dropped, f), 1, io.EOF,
+			sensors.StreamStats{Frames: 1, BadChecksums: 1, Skipped: 11}},
+		{"malformed", join(malformed, f), 1, io.EOF,
+			sensors.StreamStats{Frames: 1, Malformed: 1, Skipped: 4}},
+		// A header claiming more bytes than are left must not hide the
+		// frame after it.
+		{"stray header near end", join([]byte{19, 200}, f), 1, io.EOF,
+			sensors.StreamStats{Frames: 1, Truncated: 1, Skipped: 1}},
+		{"truncated frame", join(f, f[:7]), 1, io.ErrUnexpectedEOF,
+			sensors.StreamStats{Frames: 1, Truncated: 1, Skipped: 6}},
+		{"truncated header", join(f, f[:1]), 1, io.ErrUnexpectedEOF,
+			sensors.StreamStats{Frames: 1, Truncated: 1}},
+	}
+	for _, test := range tests {
+		s := sensors.NewStreamReader(bytes.NewReader(test.stream))
+		frames := 0
+		var err error
+		for {
+			var frame sensors.Frame
+			frame, err = s.Next()
+			if err != nil {
+				break
+			}
+			frames++
+			if !bytes.Equal(frame.IDs, streamPackets) || frame.Current != -400 || frame.Mode != sensors.Passive {
+				t.Errorf("%s: frame %d is %+v", test.name, frames, frame)
+			}
+		}
+		if frames != test.frames || err != test.err {
+			t.Errorf("%s: got %d frames then %v, want %d then %v", test.name, frames, err, test.frames, test.err)
+		}
+		if stats := s.Stats(); stats != test.stats {
+			t.Errorf("%s: stats %+v, want %+v", test.name, stats, test.stats)
+		}
+	}
+}
+
+// endless repeats a frame forever.
+type endless struct {
+	frame []byte
+	next  int
+}
+
+func (e *endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = e.frame[e.next]
+		e.next = (e.next + 1) % len(e.frame)
+	}
+	return len(p), nil
+}
+
+func TestFramesStopsWhenDone(t *testing.T) {
+	f := simFrame(t, []byte{60, 140})
+	done := make(chan struct{})
+	frames := sensors.NewStreamReader(&endless{frame: f}).Frames(done)
+	<-frames
+	close(done)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-frames:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Frames kept going after done was closed")
+		}
+	}
+}
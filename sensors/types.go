@@ -0,0 +1,160 @@
+package sensors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Bumps is packet 7, the bumpers and wheel drops.
+type Bumps struct {
+	BumpRight, BumpLeft           bool
+	WheelDropRight, WheelDropLeft bool
+}
+
+func decodeBumps(b byte) Bumps {
+	return Bumps{
+		BumpRight:      b&(1<<0) != 0,
+		BumpLeft:       b&(1<<1) != 0,
+		WheelDropRight: b&(1<<2) != 0,
+		WheelDropLeft:  b&(1<<3) != 0,
+	}
+}
+
+func (b Bumps) String() string {
+	return flags([]string{"BumpRight", "BumpLeft", "WheelDropRight", "WheelDropLeft"},
+		b.BumpRight, b.BumpLeft, b.WheelDropRight, b.WheelDropLeft)
+}
+
+// Overcurrents is packet 14, which motors are drawing too much current.
+type Overcurrents struct {
+	SideBrush, MainBrush, RightWheel, LeftWheel bool
+}
+
+func decodeOvercurrents(b byte) Overcurrents {
+	return Overcurrents{
+		SideBrush:  b&(1<<0) != 0,
+		MainBrush:  b&(1<<2) != 0,
+		RightWheel: b&(1<<3) != 0,
+		LeftWheel:  b&(1<<4) != 0,
+	}
+}
+
+func (o Overcurrents) String() string {
+	return flags([]string{"SideBrush", "MainBrush", "RightWheel", "LeftWheel"},
+		o.SideBrush, o.MainBrush, o.RightWheel, o.LeftWheel)
+}
+
+// Buttons is packet 18, the buttons being pressed.
+type Buttons struct {
+	Clean, Spot, Dock, Minute, Hour, Day, Schedule, Clock bool
+}
+
+func decodeButtons(b byte) Buttons {
+	return Buttons{
+		Clean:    b&(1<<0) != 0,
+		Spot:     b&(1<<1) != 0,
+		Dock:     b&(1<<2) != 0,
+		Minute:   b&(1<<3) != 0,
+		Hour:     b&(1<<4) != 0,
+		Day:      b&(1<<5) != 0,
+		Schedule: b&(1<<6) != 0,
+		Clock:    b&(1<<7) != 0,
+	}
+}
+
+func (b Buttons) String() string {
+	return flags([]string{"Clean", "Spot", "Dock", "Minute", "Hour", "Day", "Schedule", "Clock"},
+		b.Clean, b.Spot, b.Dock, b.Minute, b.Hour, b.Day, b.Schedule, b.Clock)
+}
+
+// ChargingSources is packet 34, the sources of charge that are available.
+type ChargingSources struct {
+	InternalCharger, HomeBase bool
+}
+
+func decodeChargingSources(b byte) ChargingSources {
+	return ChargingSources{
+		InternalCharger: b&(1<<0) != 0,
+		HomeBase:        b&(1<<1) != 0,
+	}
+}
+
+func (c ChargingSources) String() string {
+	return flags([]string{"InternalCharger", "HomeBase"}, c.InternalCharger, c.HomeBase)
+}
+
+// LightBumper is packet 45, which light bumper sensors see an obstacle.
+type LightBumper struct {
+	Left, FrontLeft, CenterLeft, CenterRight, FrontRight, Right bool
+}
+
+func decodeLightBumper(b byte) LightBumper {
+	return LightBumper{
+		Left:        b&(1<<0) != 0,
+		FrontLeft:   b&(1<<1) != 0,
+		CenterLeft:  b&(1<<2) != 0,
+		CenterRight: b&(1<<3) != 0,
+		FrontRight:  b&(1<<4) != 0,
+		Right:       b&(1<<5) != 0,
+	}
+}
+
+func (l LightBumper) String() string {
+	return flags([]string{"Left", "FrontLeft", "CenterLeft", "CenterRight", "FrontRight", "Right"},
+		l.Left, l.FrontLeft, l.CenterLeft, l.CenterRight, l.FrontRight, l.Right)
+}
+
+// flags writes the names of the set flags, such as "{BumpLeft|WheelDropLeft}".
+func flags(names []string, set ...bool) string {
+	var on []string
+	for i, s := range set {
+		if s {
+			on = append(on, names[i])
+		}
+	}
+	return "{" + strings.Join(on, "|") + "}"
+}
+
+// ChargingState is packet 21.
+type ChargingState byte
+
+const (
+	NotCharging ChargingState = iota
+	ReconditioningCharging
+	FullCharging
+	TrickleCharging
+	Waiting
+	ChargingFault
+)
+
+var chargingStateNames = []string{
+	"NotCharging", "ReconditioningCharging", "FullCharging",
+	"TrickleCharging", "Waiting", "ChargingFault",
+}
+
+func (c ChargingState) String() string {
+	if int(c) < len(chargingStateNames) {
+		return chargingStateNames[c]
+	}
+	return fmt.Sprintf("ChargingState(%d)", byte(c))
+}
+
+// Mode is packet 35, the OI mode. Noise on the link can produce values
+// outside the four modes, which String shows by number.
+type Mode byte
+
+const (
+	Off Mode = iota
+	Passive
+	Safe
+	Full
+)
+
+var modeNames = []string{"Off", "Passive", "Safe", "Full"}
+
+func (m Mode) String() string {
+	if int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return fmt.Sprintf("Mode(%d)", byte(m))
+}
@@ -0,0 +1,329 @@
+package sim
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/cquinn/doombot/sensors"
+	"github.com/xa4a/go-roomba"
+)
+
+// LEDs is the state of the LEDs set by the LEDs command.
+type LEDs struct {
+	Debris, Spot, Dock, CheckRobot bool
+	PowerColor                     byte // 0 is green, 255 is red
+	PowerIntensity                 byte // 0 is off, 255 is full
+}
+
+// MotorDuty is a cleaning motor's PWM duty cycle, out of 128. Negative
+// values run a brush against its default direction.
+type MotorDuty int
+
+// Percent returns the duty cycle as a percentage of full power.
+func (d MotorDuty) Percent() float64 {
+	return float64(d) * 100 / fullDuty
+}
+
+// Motors is the state of the cleaning motors.
+type Motors struct {
+	MainBrush, SideBrush, Vacuum MotorDuty
+}
+
+// CleaningMode is the built-in cleaning behavior the robot is running.
+type CleaningMode byte
+
+const (
+	NotCleaning CleaningMode = iota
+	CleaningClean
+	CleaningSpot
+	CleaningMax
+)
+
+func (c CleaningMode) String() string {
+	switch c {
+	case NotCleaning:
+		return "not cleaning"
+	case CleaningClean:
+		return "clean"
+	case CleaningSpot:
+		return "spot"
+	case CleaningMax:
+		return "max"
+	}
+	return "unknown"
+}
+
+// Button bits, as used by the Buttons command and packet 18.
+const (
+	ButtonClean byte = 1 << iota
+	ButtonSpot
+	ButtonDock
+	ButtonMinute
+	ButtonHour
+	ButtonDay
+	ButtonSchedule
+	ButtonClock
+)
+
+const (
+	fullDuty = 128
+	maxPWM   = 127
+
+	// Buttons pushed with the Buttons command release by themselves.
+	buttonPressTime = time.Second / 6
+
+	// Current drawn by the cleaning motors at full power, in mA.
+	mainBrushCurrent = 250.0
+	sideBrushCurrent = 80.0
+	vacuumCurrent    = 500.0
+)
+
+// LEDs returns the state of the robot's LEDs.
+func (sim *RoombaSimulator) LEDs() LEDs {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.leds
+}
+
+// DigitLEDs returns the segments lit on each of the four 7 segment digits,
+// from left to right. Bits 0-6 are segments A-G.
+func (sim *RoombaSimulator) DigitLEDs() [4]byte {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.digits
+}
+
+// DigitText returns the characters last shown on the digit display with the
+// Digit LEDs ASCII command, or "" if the display was last set segment by
+// segment.
+func (sim *RoombaSimulator) DigitText() string {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.digitText
+}
+
+// Motors returns the state of the cleaning motors.
+func (sim *RoombaSimulator) Motors() Motors {
+	sim.lock()
+	defer sim.mu.Unlock()
+	return sim.motors
+}
+
+// Cleaning returns the cleaning behavior the robot is running.
+func (sim *RoombaSimulator) Cleaning() CleaningMode {
+	sim.lock()
+	defer sim.mu.Unlock()
+	return sim.cleaning
+}
+
+// Buttons returns the bits of the buttons that are currently pressed.
+func (sim *RoombaSimulator) Buttons() byte {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.pressedButtons()
+}
+
+// Asleep reports whether the robot has been powered down by the Power
+// command, and is waiting for a Start command to wake it.
+func (sim *RoombaSimulator) Asleep() bool {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.asleep
+}
+
+// setLEDs handles the LEDs command.
+func (sim *RoombaSimulator) setLEDs(bits, color, intensity byte) {
+	sim.actuate("LEDs", func() {
+		sim.leds = LEDs{
+			Debris:         bits&(1<<0) != 0,
+			Spot:           bits&(1<<1) != 0,
+			Dock:           bits&(1<<2) != 0,
+			CheckRobot:     bits&(1<<3) != 0,
+			PowerColor:     color,
+			PowerIntensity: intensity,
+		}
+	})
+}
+
+// setDigitsRaw handles the Digit LEDs Raw command.
+func (sim *RoombaSimulator) setDigitsRaw(digits [4]byte) {
+	for i, d := range digits {
+		if d&0x80 != 0 {
+			sim.protocolError("digit %d sets reserved bit 7: %#x", 3-i, d)
+		}
+	}
+	sim.actuate("DigitLEDsRaw", func() {
+		sim.digits = digits
+		sim.digitText = ""
+	})
+}
+
+// setDigitsASCII handles the Digit LEDs ASCII command.
+func (sim *RoombaSimulator) setDigitsASCII(chars [4]byte) {
+	var digits [4]byte
+	for i, c := range chars {
+		if c < 32 || c > 126 {
+			sim.protocolError("digit %d character %d out of range 32-126", 3-i, c)
+			continue
+		}
+		digits[i] = asciiSegments(c)
+	}
+	sim.actuate("DigitLEDsASCII", func() {
+		sim.digits = digits
+		sim.digitText = string(chars[:])
+	})
+}
+
+// setMotors handles the Motors command, which runs the cleaning motors at
+// full power.
+func (sim *RoombaSimulator) setMotors(bits byte) {
+	var m Motors
+	if bits&(1<<0) != 0 {
+		m.SideBrush = fullDuty
+		if bits&(1<<3) != 0 {
+			m.SideBrush = -fullDuty
+		}
+	}
+	if bits&(1<<1) != 0 {
+		m.Vacuum = fullDuty
+	}
+	if bits&(1<<2) != 0 {
+		m.MainBrush = fullDuty
+		if bits&(1<<4) != 0 {
+			m.MainBrush = -fullDuty
+		}
+	}
+	sim.actuate("Motors", func() {
+		sim.motors = m
+	})
+}
+
+// setPWMMotors handles the PWM Motors command.
+func (sim *RoombaSimulator) setPWMMotors(mainBrush, sideBrush int8, vacuum byte) {
+	m := Motors{MotorDuty(mainBrush), MotorDuty(sideBrush), MotorDuty(vacuum)}
+	if mainBrush < -maxPWM {
+		sim.protocolError("main brush PWM %d out of range -%d-%d", mainBrush, maxPWM, maxPWM)
+		m.MainBrush = -maxPWM
+	}
+	if sideBrush < -maxPWM {
+		sim.protocolError("side brush PWM %d out of range -%d-%d", sideBrush, maxPWM, maxPWM)
+		m.SideBrush = -maxPWM
+	}
+	if vacuum > maxPWM {
+		sim.protocolError("vacuum PWM %d out of range 0-%d", vacuum, maxPWM)
+		m.Vacuum = maxPWM
+	}
+	sim.actuate("PWMMotors", func() {
+		sim.motors = m
+	})
+}
+
+// pressButtons handles the Buttons command. Pressing Clean, Spot or Dock
+// has the same effect as the matching command.
+func (sim *RoombaSimulator) pressButtons(bits byte) {
+	sim.lock()
+	defer sim.mu.Unlock()
+	log.Printf("buttons pressed: %#x", bits)
+	sim.buttons = bits
+	sim.buttonsRel = sim.now().Add(buttonPressTime)
+	switch {
+	case bits&ButtonClean != 0:
+		sim.clean(CleaningClean)
+	case bits&ButtonSpot != 0:
+		sim.clean(CleaningSpot)
+	case bits&ButtonDock != 0:
+		sim.beginSeekingDock()
+	}
+}
+
+func (sim *RoombaSimulator) pressedButtons() byte {
+	if sim.now().Before(sim.buttonsRel) {
+		return sim.buttons
+	}
+	return 0
+}
+
+// startCleaning handles the Clean, Spot and Max commands.
+func (sim *RoombaSimulator) startCleaning(c CleaningMode) {
+	sim.lock()
+	defer sim.mu.Unlock()
+	sim.clean(c)
+}
+
+// clean starts a cleaning behavior in Passive mode, or pauses it if it is
+// already running. The cleaning motors run, but the robot doesn't drive
+// itself around.
+func (sim *RoombaSimulator) clean(c CleaningMode) {
+	sim.seeking = false
+	sim.asleep = false
+	sim.setMode(sensors.Passive)
+	if sim.cleaning == c {
+		log.Printf("%s cleaning paused", c)
+		sim.cleaning = NotCleaning
+		return
+	}
+	log.Printf("%s cleaning started", c)
+	sim.cleaning = c
+	sim.motors = Motors{fullDuty, fullDuty, fullDuty}
+}
+
+// powerDown handles the Power command, which puts the robot to sleep in
+// Passive mode until it is woken by a Start command.
+func (sim *RoombaSimulator) powerDown() {
+	sim.lock()
+	defer sim.mu.Unlock()
+	log.Printf("powering down")
+	sim.seeking = false
+	sim.setMode(sensors.Passive)
+	sim.leds = LEDs{}
+	sim.digits = [4]byte{}
+	sim.digitText = ""
+	sim.asleep = true
+}
+
+// current returns the current drawn by the cleaning motors, in mA, as
+// a negative number.
+func (m Motors) current() float64 {
+	return -(math.Abs(m.MainBrush.Percent())*mainBrushCurrent +
+		math.Abs(m.SideBrush.Percent())*sideBrushCurrent +
+		math.Abs(m.Vacuum.Percent())*vacuumCurrent) / 100
+}
+
+// actuatorSensorValue returns the value of the sensor packets reporting the
+// buttons and cleaning motors, and false for any other packet.
+func (sim *RoombaSimulator) actuatorSensorValue(packetId byte) ([]byte, bool) {
+	switch packetId {
+	case sensors.PacketButtons:
+		return []byte{sim.pressedButtons()}, true
+	case sensors.PacketMainBrushMotorCurrent:
+		current := math.Abs(sim.motors.MainBrush.Percent()) * mainBrushCurrent / 100
+		return roomba.Pack([]interface{}{clampInt16(current)}), true
+	case sensors.PacketSideBrushMotorCurrent:
+		current := math.Abs(sim.motors.SideBrush.Percent()) * sideBrushCurrent / 100
+		return roomba.Pack([]interface{}{clampInt16(current)}), true
+	}
+	return nil, false
+}
+
+// sevenSegments approximates the printable ASCII characters on a 7 segment
+// digit, with bits 0-6 as segments A-G. Lower case letters show as upper
+// case, and characters without a reasonable approximation are blank.
+var sevenSegments = map[byte]byte{
+	'0': 0x3f, '1': 0x06, '2': 0x5b, '3': 0x4f, '4': 0x66,
+	'5': 0x6d, '6': 0x7d, '7': 0x07, '8': 0x7f, '9': 0x6f,
+	'A': 0x77, 'B': 0x7c, 'C': 0x39, 'D': 0x5e, 'E': 0x79, 'F': 0x71,
+	'G': 0x3d, 'H': 0x76, 'I': 0x06, 'J': 0x1e, 'K': 0x75, 'L': 0x38,
+	'M': 0x37, 'N': 0x54, 'O': 0x3f, 'P': 0x73, 'Q': 0x67, 'R': 0x50,
+	'S': 0x6d, 'T': 0x78, 'U': 0x3e, 'V': 0x3e, 'W': 0x2a, 'X': 0x76,
+	'Y': 0x6e, 'Z': 0x5b,
+	'-': 0x40, '_': 0x08, '=': 0x48, '"': 0x22, '\'': 0x02,
+	'[': 0x39, ']': 0x0f, '(': 0x39, ')': 0x0f,
+}
+
+func asciiSegments(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	return sevenSegments[c]
+}
@@ -0,0 +1,124 @@
+package sim
+
+import (
+	"log"
+	"math"
+
+	"github.com/cquinn/doombot/sensors"
+	"github.com/xa4a/go-roomba"
+)
+
+// Battery model, loosely based on the Create 2's 14.4V NiMH pack.
+const (
+	BatteryCapacity = 2696.0 // mAh, as a new Create 2 battery reports.
+
+	emptyVoltage       = 12800.0 // mV at 0% charge
+	fullVoltage        = 16400.0 // mV at 100% charge
+	internalResistance = 0.2     // ohms, so mA * ohms gives mV
+
+	offCurrent              = -20.0  // mA drawn when the OI is off
+	idleCurrent             = -180.0 // mA drawn by the electronics
+	wheelCurrentPerVelocity = 0.5    // mA drawn per mm/s of each wheel
+
+	reconditionTime    = 120.0  // seconds of reconditioning at the start of a charge
+	reconditionCurrent = 300.0  // mA
+	fullChargeCurrent  = 1500.0 // mA
+	trickleCurrent     = 50.0   // mA
+	trickleThreshold   = 0.95   // fraction of capacity where trickle charging starts
+)
+
+type battery struct {
+	charge     float64 // mAh
+	capacity   float64 // mAh
+	current    float64 // mA, negative while discharging
+	state      sensors.ChargingState
+	chargeTime float64 // seconds since charging started
+}
+
+// BatteryCharge returns the simulated battery's charge, in mAh.
+func (sim *RoombaSimulator) BatteryCharge() float64 {
+	sim.lock()
+	defer sim.mu.Unlock()
+	return sim.battery.charge
+}
+
+// SetBatteryCharge sets the simulated battery's charge, in mAh, for example
+// to start a test with a nearly flat battery.
+func (sim *RoombaSimulator) SetBatteryCharge(charge float64) {
+	sim.lock()
+	defer sim.mu.Unlock()
+	sim.battery.charge = math.Max(0, math.Min(sim.battery.capacity, charge))
+}
+
+// ChargingState returns the simulated battery's charging state.
+func (sim *RoombaSimulator) ChargingState() sensors.ChargingState {
+	sim.lock()
+	defer sim.mu.Unlock()
+	return sim.battery.state
+}
+
+// loadCurrent returns the current drawn from the battery by the robot, in
+// mA, as a negative number.
+func (sim *RoombaSimulator) loadCurrent() float64 {
+	if sim.mode == sensors.Off {
+		return offCurrent
+	}
+	return idleCurrent + sim.motors.current() -
+		wheelCurrentPerVelocity*(math.Abs(sim.rightVelocity)+math.Abs(sim.leftVelocity))
+}
+
+// updateBattery charges or drains the battery over dt seconds. The robot
+// only charges on the dock, and not in Safe or Full mode.
+func (sim *RoombaSimulator) updateBattery(dt float64) {
+	b := &sim.battery
+	if sim.docked() && (sim.mode == sensors.Off || sim.mode == sensors.Passive) {
+		b.chargeTime += dt
+		switch {
+		case b.chargeTime < reconditionTime:
+			b.state, b.current = sensors.ReconditioningCharging, reconditionCurrent
+		case b.charge < trickleThreshold*b.capacity:
+			b.state, b.current = sensors.FullCharging, fullChargeCurrent
+		default:
+			b.state, b.current = sensors.TrickleCharging, trickleCurrent
+		}
+	} else {
+		b.chargeTime = 0
+		b.state, b.current = sensors.NotCharging, sim.loadCurrent()
+	}
+
+	b.charge = math.Max(0, math.Min(b.capacity, b.charge+b.current*dt/3600))
+	if b.charge == 0 && sim.mode != sensors.Off {
+		log.Printf("battery is flat")
+		sim.setMode(sensors.Off)
+	}
+}
+
+// voltage returns the battery voltage, in mV, which sags under load.
+func (b *battery) voltage() uint16 {
+	return uint16(emptyVoltage + (fullVoltage-emptyVoltage)*b.charge/b.capacity +
+		b.current*internalResistance)
+}
+
+// batterySensorValue returns the value of the battery and charging sensor
+// packets, and false for any other packet.
+func (sim *RoombaSimulator) batterySensorValue(packetId byte) ([]byte, bool) {
+	b := &sim.battery
+	switch packetId {
+	case sensors.PacketChargingState:
+		return []byte{byte(b.state)}, true
+	case sensors.PacketVoltage:
+		return roomba.Pack([]interface{}{b.voltage()}), true
+	case sensors.PacketCurrent:
+		return roomba.Pack([]interface{}{clampInt16(b.current)}), true
+	case sensors.PacketBatteryCharge:
+		return roomba.Pack([]interface{}{uint16(b.charge)}), true
+	case sensors.PacketBatteryCapacity:
+		return roomba.Pack([]interface{}{uint16(b.capacity)}), true
+	case sensors.PacketChargingSources:
+		if sim.docked() {
+			return []byte{1 << 1}, true
+		}
+		return []byte{0}, true
+	}
+	return nil, false
+}
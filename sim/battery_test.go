@@ -0,0 +1,78 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+func TestBatteryDischarge(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmds    []oi.Command
+		current float64 // mA
+	}{
+		{"off", nil, offCurrent},
+		{"passive", []oi.Command{oi.Start{}}, idleCurrent},
+		{"driving", []oi.Command{oi.Start{}, oi.Safe{}, oi.DirectDrive{Right: 200, Left: -100}}, idleCurrent - 150},
+		{"vacuuming", []oi.Command{oi.Start{}, oi.Safe{}, oi.Motors{Bits: 0x02}}, idleCurrent - vacuumCurrent},
+	}
+	for _, test := range tests {
+		s, rw, c := newTestSim(t)
+		s.SetBatteryCharge(2000)
+		send(t, s, rw, test.cmds...)
+		c.Advance(6 * time.Minute)
+
+		want := 2000 + test.current/10
+		if got := s.BatteryCharge(); math.Abs(got-want) > 0.1 {
+			t.Errorf("%s: charge %.1fmAh after 6 minutes, want %.1f", test.name, got, want)
+		}
+		if s.Mode() == sensors.Off {
+			continue
+		}
+		if got := sensor16(t, rw, sensors.PacketCurrent); got != int16(test.current) {
+			t.Errorf("%s: current %dmA, want %.0f", test.name, got, test.current)
+		}
+		if got := sensor16(t, rw, sensors.PacketBatteryCharge); math.Abs(float64(got)-want) > 1 {
+			t.Errorf("%s: charge packet %dmAh, want %.0f", test.name, got, want)
+		}
+		if got := sensor16(t, rw, sensors.PacketBatteryCapacity); got != BatteryCapacity {
+			t.Errorf("%s: capacity packet %dmAh, want %d", test.name, got, int(BatteryCapacity))
+		}
+		if got := sensors.ChargingState(sensor(t, rw, sensors.PacketChargingState)[0]); got != sensors.NotCharging {
+			t.Errorf("%s: charging state %v, want NotCharging", test.name, got)
+		}
+	}
+}
+
+func TestBatteryVoltage(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	send(t, s, rw, oi.Start{})
+	c.Advance(time.Second)
+	s.SetBatteryCharge(BatteryCapacity / 2)
+	// Halfway between empty and full, less the sag of 180mA through the
+	// battery's resistance.
+	if got := sensor16(t, rw, sensors.PacketVoltage); got != 14600-36 {
+		t.Errorf("voltage %dmV, want %d", got, 14600-36)
+	}
+}
+
+func TestFlatBattery(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	// Enough for 100s of driving at 380mA.
+	s.SetBatteryCharge(380.0 * 100 / 3600)
+	send(t, s, rw, oi.Start{}, oi.Safe{}, oi.DirectDrive{Right: 200, Left: 200})
+	c.Advance(2 * time.Minute)
+	if m := s.Mode(); m != sensors.Off {
+		t.Errorf("mode %v with a flat battery, want Off", m)
+	}
+	if p := s.Pose(); math.Abs(p.X-20000) > 5 {
+		t.Errorf("drove to %+v, want to have stopped at X 20000", p)
+	}
+	if got := s.BatteryCharge(); got != 0 {
+		t.Errorf("charge %.1fmAh, want 0", got)
+	}
+}
@@ -0,0 +1,140 @@
+package sim
+
+import (
+	"log"
+	"strings"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+// DefaultBaud is the Create 2's baud rate after it powers up or resets.
+const DefaultBaud = 115200
+
+// baudRates are the rates selected by the Baud command's baud code.
+var baudRates = []int{
+	300, 600, 1200, 2400, 4800, 9600, 14400, 19200, 28800, 38400, 57600, 115200,
+}
+
+// BootMessage is the banner the simulated robot prints when it is reset, as
+// a Create 2 running release 3.7.5 of its firmware does.
+var BootMessage = strings.Join([]string{
+	"bl-start",
+	"STR730",
+	"bootloader id: #x47175347 4D7FFFFF",
+	"bootloader info rev: #xF000",
+	"bootloader rev: #x0001",
+	"2007-05-14-1715-L   ",
+	"Roomba by iRobot!",
+	"stm32",
+	"2014-11-17-1525-L   ",
+	"battery-current-zero 258",
+	"",
+	"2015-02-06-1516-L   ",
+	"r3_robot/tags/release-stm32-3.7.5:6216 CLEAN",
+	"",
+	"bootloader id: #x47175347 4D7FFFFF ",
+	"assembly: 3.3",
+	"revision: 0",
+	"flash version: 10",
+	"flash info crc passed: 1",
+	"",
+	"processor-sleep",
+	"",
+}, "\r\n")
+
+// Baud returns the baud rate the simulated robot is talking at.
+func (sim *RoombaSimulator) Baud() int {
+	l := sim.link
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.robotBaud
+}
+
+// SetHostBaud tells the simulator the baud rate the client's serial port is
+// set to, which starts at DefaultBaud. While it doesn't match the robot's
+// rate, bytes in both directions are garbled, as they would be on a real
+// serial line. A simulator on a pty reads the client's rate from the pty
+// instead.
+func (sim *RoombaSimulator) SetHostBaud(baud int) {
+	l := sim.link
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hostBaud = baud
+}
+
+// setBaud handles the Baud command.
+func (sim *RoombaSimulator) setBaud(code byte) {
+	if int(code) >= len(baudRates) {
+		sim.protocolError("baud code %d out of range 0-%d", code, len(baudRates)-1)
+		return
+	}
+	log.Printf("baud rate changed to %d", baudRates[code])
+	l := sim.link
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.robotBaud = baudRates[code]
+}
+
+// reset handles the Reset command. The robot reboots as if its battery had
+// been reinserted: it forgets what it was doing and its songs, goes back to
+// Off mode and the default baud rate, and prints its boot message. It stays
+// where it is, with the same battery charge.
+func (sim *RoombaSimulator) reset() {
+	log.Printf("resetting")
+	sim.lock()
+	sim.seeking = false
+	sim.cleaning = NotCleaning
+	sim.asleep = false
+	sim.setMode(sensors.Off)
+	sim.songs = [oi.SongSlots]Song{}
+	sim.songEnd = sim.now()
+	sim.streamPackets = nil
+	sim.streamPaused = false
+	sim.leds = LEDs{}
+	sim.digits = [4]byte{}
+	sim.digitText = ""
+	sim.requestedRight, sim.requestedLeft = 0, 0
+	sim.odoDistance, sim.odoAngle = 0, 0
+	sim.leftTravel, sim.rightTravel = 0, 0
+	sim.mu.Unlock()
+
+	l := sim.link
+	l.mu.Lock()
+	l.robotBaud = DefaultBaud
+	l.mu.Unlock()
+
+	sim.write([]byte(BootMessage))
+}
+
+// garble mangles bytes sent at one baud rate and received at another. A
+// receiver sampling faster than the sender sees each byte as several, and
+// one sampling slower merges several into one; either way, what arrives is
+// garbage. l.mu must be held.
+func (l *link) garble(p []byte, toRobot bool) []byte {
+	host := l.hostBaud
+	if l.detectHostBaud != nil {
+		if baud, ok := l.detectHostBaud(); ok {
+			host = baud
+		}
+	}
+	if host == l.robotBaud || host == 0 {
+		return p
+	}
+	sender, receiver := host, l.robotBaud
+	if !toRobot {
+		sender, receiver = receiver, sender
+	}
+	out := make([]byte, 0, len(p))
+	for i := range p {
+		switch {
+		case receiver > sender:
+			for j := 0; j < receiver/sender; j++ {
+				out = append(out, byte(l.rand.Intn(256)))
+			}
+		case i%(sender/receiver) == 0:
+			out = append(out, byte(l.rand.Intn(256)))
+		}
+	}
+	return out
+}
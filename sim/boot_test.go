@@ -0,0 +1,73 @@
+package sim
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+func TestBaud(t *testing.T) {
+	s, rw, _ := newTestSim(t)
+	send(t, s, rw, oi.Start{}, oi.Baud{Code: 7})
+	if got := s.Baud(); got != 19200 {
+		t.Errorf("baud %d after Baud code 7, want 19200", got)
+	}
+	// Once the client follows, they can talk again.
+	s.SetHostBaud(19200)
+	if m := sensor(t, rw, sensors.PacketMode)[0]; m != byte(sensors.Passive) {
+		t.Errorf("mode %d at 19200 baud, want Passive", m)
+	}
+
+	sendBytes(t, s, rw, oi.OpBaud, 12)
+	if got := s.Baud(); got != 19200 {
+		t.Errorf("baud %d after a bad baud code, want 19200 still", got)
+	}
+	if errs := s.ProtocolErrors(); len(errs) != 1 {
+		t.Errorf("protocol errors %v, want one for the bad baud code", errs)
+	}
+}
+
+func TestBaudMismatchGarbles(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	send(t, s, rw, oi.Start{}, oi.Stream{IDs: []byte{sensors.PacketMode}})
+	clean, _ := exchange(t, rw, c, nil, 20, streamPeriod)
+	s.SetHostBaud(57600)
+	garbled, _ := exchange(t, rw, c, nil, 20, streamPeriod)
+	if bytes.Equal(clean, garbled) {
+		t.Errorf("stream at mismatched baud rates arrived intact: % d", garbled)
+	}
+}
+
+func TestReset(t *testing.T) {
+	s, rw, _ := newTestSim(t)
+	send(t, s, rw, oi.Start{}, oi.Safe{}, oi.Song{Num: 0, Notes: []oi.Note{{Number: 60, Duration: 16}}})
+	send(t, s, rw, oi.Reset{})
+	boot := make([]byte, len(BootMessage))
+	if _, err := io.ReadFull(rw, boot); err != nil {
+		t.Fatal(err)
+	}
+	if string(boot) != BootMessage {
+		t.Errorf("reset printed %q, want the boot message", boot)
+	}
+	if m := s.Mode(); m != sensors.Off {
+		t.Errorf("mode %v after Reset, want Off", m)
+	}
+
+	send(t, s, rw, oi.Start{}, oi.Safe{}, oi.Play{Num: 0})
+	if errs := s.ProtocolErrors(); len(errs) != 1 {
+		t.Errorf("protocol errors %v, want one for playing the forgotten song", errs)
+	}
+}
+
+func TestResetRestoresBaud(t *testing.T) {
+	s, rw, _ := newTestSim(t)
+	send(t, s, rw, oi.Start{}, oi.Baud{Code: 7})
+	s.SetHostBaud(19200)
+	send(t, s, rw, oi.Reset{})
+	if got := s.Baud(); got != DefaultBaud {
+		t.Errorf("baud %d after Reset, want %d", got, DefaultBaud)
+	}
+}
@@ -0,0 +1,98 @@
+package sim
+
+import (
+	"math"
+
+	"github.com/cquinn/doombot/sensors"
+	"github.com/xa4a/go-roomba"
+)
+
+const (
+	// Cliff sensor readings over the floor and over a drop. Real sensors
+	// are noisier, but clients only care which side of the threshold the
+	// reading falls.
+	floorSignal = 2800
+	cliffSignal = 10
+)
+
+// The four cliff sensors sit just inside the front of the bumper, in the
+// order of packets 9-12: left, front left, front right and right.
+var cliffSensorBearings = [4]float64{
+	65 * math.Pi / 180,
+	20 * math.Pi / 180,
+	-20 * math.Pi / 180,
+	-65 * math.Pi / 180,
+}
+
+const cliffSensorDistance = 155.0 // mm from the robot's center
+
+// inDrop reports whether a point is over one of the world's drop-offs.
+func (w *World) inDrop(p Point) bool {
+	for _, d := range w.Drops {
+		if d.contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// fallen reports whether the robot's center has gone over a drop-off, in
+// which case it can no longer drive anywhere.
+func (sim *RoombaSimulator) fallen() bool {
+	return sim.world != nil && sim.world.inDrop(sim.pose.point())
+}
+
+// cliffs reports which of the four cliff sensors are over a drop-off.
+func (sim *RoombaSimulator) cliffs() [4]bool {
+	var cliffs [4]bool
+	if sim.world == nil {
+		return cliffs
+	}
+	for i, bearing := range cliffSensorBearings {
+		cliffs[i] = sim.world.inDrop(sim.pose.offset(cliffSensorDistance, bearing))
+	}
+	return cliffs
+}
+
+// wheelDrops reports which drive wheels have gone over a drop-off.
+func (sim *RoombaSimulator) wheelDrops() (left, right bool) {
+	if sim.world == nil {
+		return false, false
+	}
+	left = sim.world.inDrop(sim.pose.offset(WheelBase/2, math.Pi/2))
+	right = sim.world.inDrop(sim.pose.offset(WheelBase/2, -math.Pi/2))
+	return left, right
+}
+
+// cliffSensorValue returns the value of the cliff sensor packets, and false
+// for any other packet.
+func (sim *RoombaSimulator) cliffSensorValue(packetId byte) ([]byte, bool) {
+	switch {
+	case packetId >= sensors.PacketCliffLeft && packetId < sensors.PacketCliffLeft+4:
+		if sim.cliffs()[packetId-sensors.PacketCliffLeft] {
+			return []byte{1}, true
+		}
+		return []byte{0}, true
+	case packetId >= sensors.PacketCliffLeftSignal && packetId < sensors.PacketCliffLeftSignal+4:
+		signal := uint16(floorSignal)
+		if sim.cliffs()[packetId-sensors.PacketCliffLeftSignal] {
+			signal = cliffSignal
+		}
+		return roomba.Pack([]interface{}{signal}), true
+	}
+	return nil, false
+}
+
+// contains reports whether a point is inside the polygon, by counting how
+// many edges a ray from the point crosses.
+func (p Polygon) contains(q Point) bool {
+	inside := false
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		a, b := p[i], p[j]
+		if (a.Y > q.Y) != (b.Y > q.Y) &&
+			q.X < (b.X-a.X)*(q.Y-a.Y)/(b.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+	return inside
+}
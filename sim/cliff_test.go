@@ -0,0 +1,69 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+func TestCliffs(t *testing.T) {
+	// The front cliff sensors are this far ahead of the robot's center.
+	front := cliffSensorDistance * math.Cos(20*math.Pi/180)
+	tests := []struct {
+		name  string
+		start Pose
+		enter oi.Command // Safe or Full
+		drive oi.Command
+		// After 10s: where the robot is, its mode, and packets 7 and 9-12.
+		wantX  float64
+		mode   sensors.Mode
+		bumps  byte
+		cliffs [4]byte
+	}{
+		{"safe", Pose{X: 1500, Y: 1000}, oi.Safe{}, oi.DirectDrive{Right: 200, Left: 200},
+			2000 - front, sensors.Passive, 0, [4]byte{0, 1, 1, 0}},
+		{"full", Pose{X: 1500, Y: 1000}, oi.Full{}, oi.DirectDrive{Right: 200, Left: 200},
+			2000, sensors.Full, 0x0c, [4]byte{1, 1, 1, 1}},
+		// Reversing isn't stopped by the cliff sensors, but a wheel drop
+		// is.
+		{"reversing", Pose{X: 1500, Y: 1000, Heading: math.Pi}, oi.Safe{}, oi.DirectDrive{Right: -200, Left: -200},
+			2000, sensors.Passive, 0x0c, [4]byte{0, 0, 0, 0}},
+		{"standing at the edge", Pose{X: 1900, Y: 1000}, oi.Safe{}, oi.DirectDrive{},
+			1900, sensors.Safe, 0, [4]byte{0, 1, 1, 0}},
+		{"spinning at the edge", Pose{X: 1900, Y: 1000}, oi.Safe{}, oi.Drive{Velocity: 100, Radius: oi.TurnClockwise},
+			1900, sensors.Passive, 0, [4]byte{0, 1, 1, 0}},
+	}
+	for _, test := range tests {
+		w := NewWorld(3000, 2000)
+		if err := w.AddDrop(Polygon{{2000, 0}, {3000, 0}, {3000, 2000}, {2000, 2000}}); err != nil {
+			t.Fatal(err)
+		}
+		w.Start = test.start
+		s, rw, c := newTestSim(t)
+		s.SetWorld(w)
+		send(t, s, rw, oi.Start{}, test.enter, test.drive)
+		c.Advance(10 * time.Second)
+
+		if p := s.Pose(); math.Abs(p.X-test.wantX) > 4 {
+			t.Errorf("%s: stopped at %+v, want X %.0f", test.name, p, test.wantX)
+		}
+		if m := s.Mode(); m != test.mode {
+			t.Errorf("%s: mode %v, want %v", test.name, m, test.mode)
+		}
+		if got := sensor(t, rw, sensors.PacketBumps)[0]; got != test.bumps {
+			t.Errorf("%s: bumps and wheel drops %#02x, want %#02x", test.name, got, test.bumps)
+		}
+		for i, want := range test.cliffs {
+			if got := sensor(t, rw, sensors.PacketCliffLeft+byte(i))[0]; got != want {
+				t.Errorf("%s: cliff packet %d is %d, want %d", test.name, sensors.PacketCliffLeft+byte(i), got, want)
+			}
+			signal := uint16(sensor16(t, rw, sensors.PacketCliffLeftSignal+byte(i)))
+			if (signal == cliffSignal) != (want == 1) {
+				t.Errorf("%s: cliff signal packet %d is %d with cliff %d", test.name, sensors.PacketCliffLeftSignal+byte(i), signal, want)
+			}
+		}
+	}
+}
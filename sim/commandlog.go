@@ -0,0 +1,60 @@
+package sim
+
+import (
+	"bytes"
+	"log"
+	"time"
+
+	"github.com/cquinn/doombot/oi"
+)
+
+// commandLogSize is the number of commands the simulator remembers; older
+// ones are dropped, so a long-running simulator doesn't grow forever.
+const commandLogSize = 10000
+
+// LoggedCommand is a command the simulator has executed, and when it
+// arrived.
+type LoggedCommand struct {
+	Time    time.Time
+	Command oi.Command
+}
+
+func (c LoggedCommand) String() string {
+	return c.Time.Format("15:04:05.000") + " " + c.Command.String()
+}
+
+// logCommand decodes the bytes of the command just executed into the log.
+func (sim *RoombaSimulator) logCommand(at time.Time) {
+	cmd, err := oi.NewDecoder(bytes.NewReader(sim.cmdBytes)).Decode()
+	if err != nil {
+		// The simulator read fewer bytes than the command takes, because
+		// it doesn't support it.
+		log.Printf("can't decode command %v: %v", sim.cmdBytes, err)
+		cmd = oi.Unknown{Op: sim.cmdBytes[0]}
+	}
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if len(sim.commands) == commandLogSize {
+		sim.commands = append(sim.commands[:0], sim.commands[1:]...)
+	}
+	sim.commands = append(sim.commands, LoggedCommand{at, cmd})
+}
+
+// Commands returns the commands the simulator has executed, oldest first.
+// Commands ignored while the robot is off or asleep aren't included.
+func (sim *RoombaSimulator) Commands() []LoggedCommand {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return append([]LoggedCommand(nil), sim.commands...)
+}
+
+// TakeCommands is like Commands, but also clears the log, so that the next
+// call only returns newer commands.
+func (sim *RoombaSimulator) TakeCommands() []LoggedCommand {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	cmds := sim.commands
+	sim.commands = nil
+	return cmds
+}
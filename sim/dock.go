@@ -0,0 +1,235 @@
+package sim
+
+import (
+	"log"
+	"math"
+
+	"github.com/cquinn/doombot/sensors"
+)
+
+const (
+	// A docked robot sits with its center this far out from the dock's
+	// charging contacts, facing the dock.
+	dockedOffset = RobotRadius
+	// How far off the docked position the robot can be and still make
+	// contact with the charger.
+	dockedRange = 30.0               // mm
+	dockedAngle = 30 * math.Pi / 180 // radians
+)
+
+// Dock is a Home Base charging dock. Its pose is the position of the
+// charging contacts and the direction the dock faces, out into the room.
+type Dock struct {
+	Pose
+}
+
+// dockedPose returns where the robot sits when it is on the dock.
+func (d *Dock) dockedPose() Pose {
+	p := d.offset(dockedOffset, 0)
+	return Pose{X: p.X, Y: p.Y, Heading: normalizeAngle(d.Heading + math.Pi)}
+}
+
+// docked reports whether the robot is on the world's dock, with its
+// charging contacts touching.
+func (sim *RoombaSimulator) docked() bool {
+	if sim.world == nil || sim.world.Dock == nil {
+		return false
+	}
+	want := sim.world.Dock.dockedPose()
+	return sim.pose.point().dist(want.point()) <= dockedRange &&
+		math.Abs(normalizeAngle(sim.pose.Heading-want.Heading)) <= dockedAngle
+}
+
+// IR characters sent by the Roomba 600 Home Base, which are combined with
+// irDockBase to say which beams a receiver is in.
+const (
+	irDockBase   byte = 160
+	irForceField byte = 1
+	irGreenBuoy  byte = 4
+	irRedBuoy    byte = 8
+)
+
+// Dock beam and IR receiver geometry.
+const (
+	forceFieldRange = 600.0              // mm
+	buoyRange       = 2500.0             // mm
+	buoySpread      = 60 * math.Pi / 180 // either side of the dock's heading
+	buoyOverlap     = 5 * math.Pi / 180  // where both buoys can be seen
+	receiverSpread  = 60 * math.Pi / 180 // field of view of the left and right receivers
+	receiverOverlap = 10 * math.Pi / 180 // where both receivers see straight ahead
+)
+
+// Seek Dock behavior parameters.
+const (
+	seekSpeed            = 200.0 // mm/s
+	seekFinalSpeed       = 100.0 // mm/s
+	seekTurnSpeed        = 100.0 // mm/s of each wheel when turning in place
+	seekSteering         = 2.0   // turn rate, in radians/s, per radian off course
+	seekTurnAngle        = 10 * math.Pi / 180
+	dockApproachDistance = 600.0 // mm out from the dock to line up on
+	dockApproachRange    = 30.0  // mm, close enough to the approach point
+)
+
+// irCharacter returns the character the dock's beams send to a receiver at
+// the given point, or 0 if it is out of all the beams or out of sight.
+func (w *World) irCharacter(p Point) byte {
+	if w.Dock == nil {
+		return 0
+	}
+	d := w.Dock
+	r := p.dist(d.point())
+	bearing := normalizeAngle(math.Atan2(p.Y-d.Y, p.X-d.X) - d.Heading)
+	if r > buoyRange || math.Abs(bearing) > math.Pi/2 || !w.lineOfSight(p, d.point()) {
+		return 0
+	}
+	var ir byte
+	if r <= forceFieldRange {
+		ir |= irForceField
+	}
+	if bearing >= -buoyOverlap && bearing <= buoySpread {
+		ir |= irRedBuoy
+	}
+	if bearing <= buoyOverlap && bearing >= -buoySpread {
+		ir |= irGreenBuoy
+	}
+	if ir == 0 {
+		return 0
+	}
+	return irDockBase | ir
+}
+
+// irReceivers returns the characters seen by the robot's omnidirectional,
+// left and right IR receivers, from the dock and the other robots' beacons.
+// The left and right receivers only see what is in front of them. Each
+// receiver sees the nearest sender in its view.
+func (sim *RoombaSimulator) irReceivers() (omni, left, right byte) {
+	if sim.world == nil {
+		return 0, 0, 0
+	}
+	type sender struct {
+		c byte
+		p Point
+	}
+	var senders []sender
+	if c := sim.world.irCharacter(sim.pose.point()); c != 0 {
+		senders = append(senders, sender{c, sim.world.Dock.point()})
+	}
+	for _, b := range sim.beacons(sim.pose.point()) {
+		senders = append(senders, sender{b.beacon, b.pose.point()})
+	}
+
+	center := sim.pose.point()
+	omniDist, leftDist, rightDist := math.Inf(1), math.Inf(1), math.Inf(1)
+	for _, s := range senders {
+		d := center.dist(s.p)
+		if d < omniDist {
+			omni, omniDist = s.c, d
+		}
+		bearing := normalizeAngle(math.Atan2(s.p.Y-sim.pose.Y, s.p.X-sim.pose.X) - sim.pose.Heading)
+		if bearing >= -receiverOverlap && bearing <= receiverSpread && d < leftDist {
+			left, leftDist = s.c, d
+		}
+		if bearing <= receiverOverlap && bearing >= -receiverSpread && d < rightDist {
+			right, rightDist = s.c, d
+		}
+	}
+	return omni, left, right
+}
+
+// irSensorValue returns the value of the IR character packets, and false
+// for any other packet.
+func (sim *RoombaSimulator) irSensorValue(packetId byte) ([]byte, bool) {
+	omni, left, right := sim.irReceivers()
+	switch packetId {
+	case sensors.PacketIROmni:
+		return []byte{omni}, true
+	case sensors.PacketIRLeft:
+		return []byte{left}, true
+	case sensors.PacketIRRight:
+		return []byte{right}, true
+	}
+	return nil, false
+}
+
+// startSeekingDock starts the built-in docking behavior, as the Seek Dock
+// command does.
+func (sim *RoombaSimulator) startSeekingDock() {
+	sim.lock()
+	defer sim.mu.Unlock()
+	sim.beginSeekingDock()
+}
+
+// beginSeekingDock starts the Seek Dock behavior, which also ends any
+// cleaning.
+func (sim *RoombaSimulator) beginSeekingDock() {
+	sim.asleep = false
+	sim.setMode(sensors.Passive)
+	sim.cleaning = NotCleaning
+	sim.seeking = true
+	sim.homing = false
+	log.Printf("seeking dock")
+}
+
+// seekDock steers the robot for one physics step of the docking behavior.
+// The robot turns on the spot until it picks up the dock's beams, then
+// lines up in front of the dock and drives straight on.
+func (sim *RoombaSimulator) seekDock() {
+	if sim.docked() {
+		log.Printf("docked")
+		sim.seeking = false
+		sim.rightVelocity, sim.leftVelocity = 0, 0
+		return
+	}
+	if sim.world == nil || sim.world.Dock == nil {
+		// There are no beams to find, so keep searching for them.
+		sim.homing = false
+		sim.rightVelocity, sim.leftVelocity = seekTurnSpeed, -seekTurnSpeed
+		return
+	}
+	if !sim.homing {
+		if sim.world.irCharacter(sim.pose.point()) == 0 {
+			sim.rightVelocity, sim.leftVelocity = seekTurnSpeed, -seekTurnSpeed
+			return
+		}
+		log.Printf("found dock beams")
+		sim.homing = true
+		sim.finalApproach = false
+	}
+
+	d := sim.world.Dock
+	approach := d.offset(dockApproachDistance, 0)
+	if sim.pose.point().dist(approach) <= dockApproachRange {
+		sim.finalApproach = true
+	}
+	target, speed := approach, seekSpeed
+	if sim.finalApproach {
+		target, speed = d.dockedPose().point(), seekFinalSpeed
+	}
+
+	bearing := normalizeAngle(math.Atan2(target.Y-sim.pose.Y, target.X-sim.pose.X) - sim.pose.Heading)
+	if math.Abs(bearing) > seekTurnAngle {
+		turn := math.Copysign(seekTurnSpeed, bearing)
+		sim.rightVelocity, sim.leftVelocity = turn, -turn
+		return
+	}
+	turnRate := seekSteering * bearing
+	sim.rightVelocity = speed + turnRate*WheelBase/2
+	sim.leftVelocity = speed - turnRate*WheelBase/2
+}
+
+// lineOfSight reports whether nothing in the world blocks the straight line
+// between two points. Geometry touching either end doesn't count, so that
+// the dock can stand against a wall.
+func (w *World) lineOfSight(a, b Point) bool {
+	length := a.dist(b)
+	if length == 0 {
+		return true
+	}
+	dir := Point{(b.X - a.X) / length, (b.Y - a.Y) / length}
+	for _, s := range w.segments {
+		if d, ok := s.intersectRay(a, dir); ok && d > 1 && d < length-1 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+// dockWorld returns a world with a dock on its left wall, facing into the
+// room.
+func dockWorld() *World {
+	w := NewWorld(4000, 3000)
+	w.Dock = &Dock{Pose{X: 0, Y: 1500}}
+	return w
+}
+
+func TestCharging(t *testing.T) {
+	w := dockWorld()
+	w.Start = w.Dock.dockedPose()
+	s, rw, c := newTestSim(t)
+	s.SetWorld(w)
+	s.SetBatteryCharge(1000)
+	send(t, s, rw, oi.Start{})
+
+	steps := []struct {
+		after   time.Duration
+		state   sensors.ChargingState
+		current int16
+		charge  float64 // mAh, if it is worth checking
+	}{
+		{time.Minute, sensors.ReconditioningCharging, reconditionCurrent, 1000 + 300.0/60},
+		// Two minutes reconditioning, then eight at the full rate.
+		{10 * time.Minute, sensors.FullCharging, fullChargeCurrent, 1000 + 300.0*2/60 + 1500.0*8/60},
+		{2 * time.Hour, sensors.TrickleCharging, trickleCurrent, 0},
+	}
+	start := c.Now()
+	for _, step := range steps {
+		c.Set(start.Add(step.after))
+		if got := sensors.ChargingState(sensor(t, rw, sensors.PacketChargingState)[0]); got != step.state {
+			t.Errorf("after %v: charging state %v, want %v", step.after, got, step.state)
+		}
+		if got := sensor16(t, rw, sensors.PacketCurrent); got != step.current {
+			t.Errorf("after %v: current %dmA, want %d", step.after, got, step.current)
+		}
+		if got := sensor(t, rw, sensors.PacketChargingSources)[0]; got != 0x02 {
+			t.Errorf("after %v: charging sources %#02x, want the home base", step.after, got)
+		}
+		if got := s.BatteryCharge(); step.charge != 0 && math.Abs(got-step.charge) > 0.5 {
+			t.Errorf("after %v: charge %.1fmAh, want %.1f", step.after, got, step.charge)
+		}
+	}
+}
+
+func TestSafeModeOnTheDock(t *testing.T) {
+	w := dockWorld()
+	w.Start = w.Dock.dockedPose()
+	s, rw, c := newTestSim(t)
+	s.SetWorld(w)
+	send(t, s, rw, oi.Start{}, oi.Safe{})
+	c.Advance(2 * time.Second)
+	if m := s.Mode(); m != sensors.Passive {
+		t.Errorf("mode %v on the dock, want Passive", m)
+	}
+	if got := sensors.ChargingState(sensor(t, rw, sensors.PacketChargingState)[0]); got != sensors.ReconditioningCharging {
+		t.Errorf("charging state %v, want ReconditioningCharging", got)
+	}
+}
+
+func TestSeekDock(t *testing.T) {
+	w := dockWorld()
+	// Off to one side of the dock, facing away from it.
+	w.Start = Pose{X: 1500, Y: 2200, Heading: math.Pi / 3}
+	s, rw, c := newTestSim(t)
+	s.SetWorld(w)
+	send(t, s, rw, oi.Start{}, oi.SeekDock{})
+	for i := 0; i < 60 && s.ChargingState() == sensors.NotCharging; i++ {
+		c.Advance(time.Second)
+	}
+
+	want := w.Dock.dockedPose()
+	if p := s.Pose(); math.Hypot(p.X-want.X, p.Y-want.Y) > dockedRange {
+		t.Errorf("stopped at %+v, want to be docked at %+v", p, want)
+	}
+	if got := sensor(t, rw, sensors.PacketChargingSources)[0]; got != 0x02 {
+		t.Errorf("charging sources %#02x, want the home base", got)
+	}
+	if m := s.Mode(); m != sensors.Passive {
+		t.Errorf("mode %v, want Passive", m)
+	}
+	// Once docked, the robot stays put.
+	p := s.Pose()
+	c.Advance(10 * time.Second)
+	if q := s.Pose(); q != p {
+		t.Errorf("moved from %+v to %+v after docking", p, q)
+	}
+}
+
+func TestDockBeams(t *testing.T) {
+	tests := []struct {
+		name string
+		at   Pose
+		omni byte
+	}{
+		{"force field", Pose{X: 400, Y: 1500}, irDockBase | irForceField | irRedBuoy | irGreenBuoy},
+		{"both buoys", Pose{X: 1500, Y: 1500}, irDockBase | irRedBuoy | irGreenBuoy},
+		{"red buoy", Pose{X: 1500, Y: 2200}, irDockBase | irRedBuoy},
+		{"green buoy", Pose{X: 1500, Y: 800}, irDockBase | irGreenBuoy},
+		{"out of range", Pose{X: 3500, Y: 1500}, 0},
+	}
+	for _, test := range tests {
+		w := dockWorld()
+		w.Start = test.at
+		s, rw, _ := newTestSim(t)
+		s.SetWorld(w)
+		send(t, s, rw, oi.Start{})
+		if got := sensor(t, rw, sensors.PacketIROmni)[0]; got != test.omni {
+			t.Errorf("%s: omni IR %d, want %d", test.name, got, test.omni)
+		}
+	}
+}
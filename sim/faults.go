@@ -0,0 +1,271 @@
+package sim
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/cquinn/doombot/clock"
+)
+
+// Faults describes impairments of the link between the simulator and its
+// client, for testing how control code copes with a flaky connection. The
+// zero value is a perfect link.
+type Faults struct {
+	// Latency delays everything the robot sends, and Jitter adds a random
+	// extra delay of up to its value. Bytes still arrive in order.
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// DropRate and CorruptRate are the chances of each byte, in either
+	// direction, being lost or having a bit flipped.
+	DropRate    float64
+	CorruptRate float64
+
+	// StallAfter stalls the link in both directions once the robot has
+	// received that many bytes, for StallFor or, if that is zero, until the
+	// simulator is stopped. DisconnectAfter drops the connection once the
+	// robot has received that many bytes. Counting starts from SetFaults,
+	// and zero disables either.
+	StallAfter      int
+	StallFor        time.Duration
+	DisconnectAfter int
+
+	// CorruptChecksumRate is the chance of a stream frame being sent with
+	// a bad checksum.
+	CorruptChecksumRate float64
+
+	// Seed seeds the random choices, so that a failing run can be
+	// reproduced.
+	Seed int64
+}
+
+var errDisconnected = errors.New("link disconnected by fault injection")
+
+// link carries the simulator's traffic to and from its connection,
+// impairing it as configured.
+type link struct {
+	rw    io.ReadWriter
+	clock clock.Clock
+	quit  <-chan struct{}
+
+	mu           sync.Mutex
+	faults       Faults
+	rand         *rand.Rand
+	pending      []byte // bytes read from rw, but not yet by the robot
+	raw          int64  // bytes read from rw
+	received     int    // bytes received by the robot since SetFaults
+	stalled      bool   // the stall has happened
+	stallUntil   time.Time
+	disconnected bool
+	lastDelivery time.Time
+
+	// Baud rates at each end of the link, also guarded by mu.
+	robotBaud      int
+	hostBaud       int
+	detectHostBaud func() (int, bool) // the host's rate, if the link can tell
+}
+
+func newLink(rw io.ReadWriter, c clock.Clock, quit <-chan struct{}) *link {
+	return &link{
+		rw:        rw,
+		clock:     c,
+		quit:      quit,
+		rand:      rand.New(rand.NewSource(0)),
+		robotBaud: DefaultBaud,
+		hostBaud:  DefaultBaud,
+	}
+}
+
+// SetFaults impairs the link between the simulator and its client from now
+// on. Passing the zero Faults restores a perfect link, except that one that
+// has been disconnected stays disconnected.
+func (sim *RoombaSimulator) SetFaults(f Faults) {
+	log.Printf("link faults: %+v", f)
+	l := sim.link
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.faults = f
+	l.rand = rand.New(rand.NewSource(f.Seed))
+	l.received = 0
+	l.stalled = false
+	l.stallUntil = time.Time{}
+}
+
+// Read passes bytes from the client to the robot.
+func (l *link) Read(p []byte) (int, error) {
+	for {
+		l.mu.Lock()
+		l.trip()
+		disconnected := l.disconnected
+		l.mu.Unlock()
+		if disconnected {
+			return 0, errDisconnected
+		}
+		if err := l.waitForStall(); err != nil {
+			return 0, err
+		}
+
+		l.mu.Lock()
+		if len(l.pending) == 0 {
+			l.mu.Unlock()
+			buf := make([]byte, len(p))
+			n, err := l.rw.Read(buf)
+			l.mu.Lock()
+			l.raw += int64(n)
+			l.pending = append(l.pending, l.impair(buf[:n], true)...)
+			if len(l.pending) == 0 {
+				l.mu.Unlock()
+				if err != nil {
+					return 0, err
+				}
+				continue
+			}
+		}
+		n := l.deliver(p)
+		l.mu.Unlock()
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// caughtUp returns the number of bytes read from the connection, and
+// whether the robot has received all of them.
+func (l *link) caughtUp() (int64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.raw, len(l.pending) == 0
+}
+
+// deliver copies pending bytes to p, up to the point where the link stalls
+// or disconnects. l.mu must be held.
+func (l *link) deliver(p []byte) int {
+	n := 0
+	for n < len(p) && len(l.pending) > 0 {
+		p[n] = l.pending[0]
+		l.pending = l.pending[1:]
+		l.received++
+		n++
+		if l.trip() {
+			break
+		}
+	}
+	return n
+}
+
+// trip stalls or disconnects the link if the robot has received enough
+// bytes, and reports whether it did. l.mu must be held.
+func (l *link) trip() bool {
+	if l.faults.DisconnectAfter > 0 && l.received >= l.faults.DisconnectAfter && !l.disconnected {
+		log.Printf("link disconnected after %d bytes", l.received)
+		l.disconnected = true
+		l.pending = nil
+		return true
+	}
+	if l.faults.StallAfter > 0 && l.received >= l.faults.StallAfter && !l.stalled {
+		log.Printf("link stalled after %d bytes", l.received)
+		l.stalled = true
+		l.stallUntil = l.clock.Now().Add(l.faults.StallFor)
+		return true
+	}
+	return false
+}
+
+// Write passes bytes from the robot to the client.
+func (l *link) Write(p []byte) (int, error) {
+	if err := l.waitForStall(); err != nil {
+		return 0, err
+	}
+	l.mu.Lock()
+	if l.disconnected {
+		l.mu.Unlock()
+		return 0, errDisconnected
+	}
+	delay := l.faults.Latency
+	if l.faults.Jitter > 0 {
+		delay += time.Duration(l.rand.Int63n(int64(l.faults.Jitter) + 1))
+	}
+	// Jitter mustn't reorder what is sent.
+	deliverAt := l.clock.Now().Add(delay)
+	if deliverAt.Before(l.lastDelivery) {
+		deliverAt = l.lastDelivery
+	}
+	l.lastDelivery = deliverAt
+	out := l.impair(p, false)
+	l.mu.Unlock()
+
+	if err := l.sleepUntil(deliverAt); err != nil {
+		return 0, err
+	}
+	if len(out) > 0 {
+		if _, err := l.rw.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	// As far as the robot knows, everything was sent.
+	return len(p), nil
+}
+
+// impair garbles bytes sent at the wrong baud rate, then drops and corrupts
+// bytes as configured. l.mu must be held.
+func (l *link) impair(p []byte, toRobot bool) []byte {
+	p = l.garble(p, toRobot)
+	if l.faults.DropRate == 0 && l.faults.CorruptRate == 0 {
+		return p
+	}
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		if l.rand.Float64() < l.faults.DropRate {
+			continue
+		}
+		if l.rand.Float64() < l.faults.CorruptRate {
+			b ^= 1 << uint(l.rand.Intn(8))
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// corruptChecksum returns a checksum, made bad if the link is configured to
+// corrupt stream frames and this frame is unlucky.
+func (l *link) corruptChecksum(checksum byte) byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.faults.CorruptChecksumRate > 0 && l.rand.Float64() < l.faults.CorruptChecksumRate {
+		return checksum + byte(1+l.rand.Intn(255))
+	}
+	return checksum
+}
+
+// waitForStall blocks while the link is stalled.
+func (l *link) waitForStall() error {
+	l.mu.Lock()
+	stalled, until, forever := l.stalled, l.stallUntil, l.faults.StallFor == 0
+	l.mu.Unlock()
+	if !stalled {
+		return nil
+	}
+	if forever {
+		<-l.quit
+		return errDisconnected
+	}
+	return l.sleepUntil(until)
+}
+
+// sleepUntil waits until t, or fails if the simulator stops first.
+func (l *link) sleepUntil(t time.Time) error {
+	d := t.Sub(l.clock.Now())
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-l.clock.After(d):
+		return nil
+	case <-l.quit:
+		return errDisconnected
+	}
+}
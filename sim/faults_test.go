@@ -0,0 +1,126 @@
+package sim
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/cquinn/doombot/clock"
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+// exchange writes query, if any, and reads n bytes back, advancing the
+// clock a step at a time until they arrive. It returns them and how much
+// virtual time passed. Writing and reading run alongside the clock, since
+// a faulty link may hold either up until time passes.
+func exchange(t *testing.T, rw *readWriter, c *clock.Manual, query []byte, n int, step time.Duration) ([]byte, time.Duration) {
+	t.Helper()
+	start := c.Now()
+	if len(query) > 0 {
+		go rw.Write(query)
+	}
+	answer := make([]byte, n)
+	read := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(rw, answer)
+		read <- err
+	}()
+	for i := 0; i < 10000; i++ {
+		select {
+		case err := <-read:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return answer, c.Now().Sub(start)
+		case <-time.After(time.Millisecond):
+			c.Advance(step)
+		}
+	}
+	t.Fatalf("no answer to % d after %v", query, c.Now().Sub(start))
+	return nil, 0
+}
+
+func TestLatency(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	send(t, s, rw, oi.Start{})
+	s.SetFaults(Faults{Latency: 200 * time.Millisecond})
+	answer, took := exchange(t, rw, c, []byte{oi.OpSensors, sensors.PacketMode}, 1, 10*time.Millisecond)
+	if answer[0] != byte(sensors.Passive) {
+		t.Errorf("mode %d, want Passive", answer[0])
+	}
+	if took < 200*time.Millisecond {
+		t.Errorf("answer took %v, want at least the 200ms latency", took)
+	}
+}
+
+func TestStall(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	s.SetFaults(Faults{StallAfter: 1, StallFor: time.Second})
+	// The Start gets through and stalls the link behind it.
+	answer, took := exchange(t, rw, c, []byte{oi.OpStart, oi.OpSensors, sensors.PacketMode}, 1, 50*time.Millisecond)
+	if answer[0] != byte(sensors.Passive) {
+		t.Errorf("mode %d, want Passive", answer[0])
+	}
+	if took < time.Second {
+		t.Errorf("answer took %v, want at least the 1s stall", took)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	s, rw, _ := newTestSim(t)
+	s.SetFaults(Faults{DisconnectAfter: 2})
+	go rw.Write([]byte{oi.OpStart, oi.OpSafe, oi.OpFull})
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("simulator still running after the link disconnected")
+	}
+	if err := s.Err(); err != errDisconnected {
+		t.Errorf("simulator stopped with %v, want %v", err, errDisconnected)
+	}
+	// The byte that tripped the disconnect was still received.
+	if m := s.Mode(); m != sensors.Safe {
+		t.Errorf("mode %v, want Safe", m)
+	}
+}
+
+func TestCorruptChecksum(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	send(t, s, rw, oi.Start{}, oi.Stream{IDs: []byte{sensors.PacketMode}})
+	s.SetFaults(Faults{CorruptChecksumRate: 1})
+	// Each frame is the header, a length of 2, the packet and its value,
+	// and the checksum.
+	frames, _ := exchange(t, rw, c, nil, 3*5, streamPeriod)
+	for i := 0; i < len(frames); i += 5 {
+		f := frames[i : i+5]
+		var sum byte
+		for _, b := range f {
+			sum += b
+		}
+		if f[0] != sensors.StreamHeader || f[3] != byte(sensors.Passive) || sum == 0 {
+			t.Errorf("frame % d, want Passive mode with a bad checksum", f)
+		}
+	}
+}
+
+func TestDropsAreSeeded(t *testing.T) {
+	// stream returns what reaches the client of a robot streaming its mode
+	// over a link dropping and corrupting bytes.
+	stream := func(f Faults) []byte {
+		s, rw, c := newTestSim(t)
+		send(t, s, rw, oi.Start{}, oi.Stream{IDs: []byte{sensors.PacketMode}})
+		s.SetFaults(f)
+		b, _ := exchange(t, rw, c, nil, 50, streamPeriod)
+		return b
+	}
+	clean := stream(Faults{})
+	lossy := stream(Faults{DropRate: 0.2, CorruptRate: 0.1, Seed: 1})
+	if bytes.Equal(clean, lossy) {
+		t.Errorf("lossy link delivered the clean stream % d", clean)
+	}
+	if again := stream(Faults{DropRate: 0.2, CorruptRate: 0.1, Seed: 1}); !bytes.Equal(lossy, again) {
+		t.Errorf("the same seed delivered % d, then % d", lossy, again)
+	}
+}
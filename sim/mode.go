@@ -0,0 +1,74 @@
+package sim
+
+import (
+	"log"
+	"math"
+
+	"github.com/cquinn/doombot/sensors"
+)
+
+// Mode returns the simulator's current OI mode.
+func (sim *RoombaSimulator) Mode() sensors.Mode {
+	sim.lock()
+	defer sim.mu.Unlock()
+	return sim.mode
+}
+
+// setMode switches OI mode. Dropping out of Safe or Full mode stops the
+// drive and cleaning motors, since the client is no longer in control of
+// them. The physics must already be up to date.
+func (sim *RoombaSimulator) setMode(m sensors.Mode) {
+	if m == sensors.Off || m == sensors.Passive {
+		sim.rightVelocity, sim.leftVelocity = 0, 0
+		sim.motors = Motors{}
+	}
+	if m != sim.mode {
+		log.Printf("switched to %s mode", m)
+	}
+	sim.mode = m
+}
+
+// actuate applies the effect of an actuator command, unless the current
+// mode doesn't allow the client to control the actuators.
+func (sim *RoombaSimulator) actuate(command string, f func()) {
+	sim.lock()
+	defer sim.mu.Unlock()
+	if sim.mode != sensors.Safe && sim.mode != sensors.Full {
+		log.Printf("ignoring %s in %s mode", command, sim.mode)
+		return
+	}
+	f()
+}
+
+// checkSafety reverts Safe mode to Passive, stopping the motors, if one of
+// the OI's safety conditions has occurred: a wheel drop, the charger being
+// connected, or a cliff while driving forward or turning tightly.
+func (sim *RoombaSimulator) checkSafety() {
+	if sim.mode != sensors.Safe {
+		return
+	}
+	if sim.docked() {
+		log.Printf("charger connected in safe mode")
+		sim.setMode(sensors.Passive)
+		return
+	}
+	if left, right := sim.wheelDrops(); left || right {
+		log.Printf("wheel drop in safe mode")
+		sim.setMode(sensors.Passive)
+		return
+	}
+	velocity := (sim.rightVelocity + sim.leftVelocity) / 2
+	turnRate := (sim.rightVelocity - sim.leftVelocity) / WheelBase
+	forward := velocity > 0
+	tightTurn := turnRate != 0 && math.Abs(velocity) < math.Abs(turnRate)*RobotRadius
+	if !forward && !tightTurn {
+		return
+	}
+	for _, cliff := range sim.cliffs() {
+		if cliff {
+			log.Printf("cliff in safe mode")
+			sim.setMode(sensors.Passive)
+			return
+		}
+	}
+}
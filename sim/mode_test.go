@@ -0,0 +1,68 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+func TestModes(t *testing.T) {
+	tests := []struct {
+		name string
+		cmds []oi.Command
+		want sensors.Mode
+	}{
+		{"powered up", nil, sensors.Off},
+		{"start", []oi.Command{oi.Start{}}, sensors.Passive},
+		{"safe", []oi.Command{oi.Start{}, oi.Safe{}}, sensors.Safe},
+		{"control", []oi.Command{oi.Start{}, oi.Control{}}, sensors.Safe},
+		{"full", []oi.Command{oi.Start{}, oi.Full{}}, sensors.Full},
+		{"safe to full", []oi.Command{oi.Start{}, oi.Safe{}, oi.Full{}}, sensors.Full},
+		{"back to passive", []oi.Command{oi.Start{}, oi.Full{}, oi.Start{}}, sensors.Passive},
+		{"stop", []oi.Command{oi.Start{}, oi.Safe{}, oi.Stop{}}, sensors.Off},
+		{"restart", []oi.Command{oi.Start{}, oi.Stop{}, oi.Start{}}, sensors.Passive},
+		{"reset", []oi.Command{oi.Start{}, oi.Full{}, oi.Reset{}}, sensors.Off},
+	}
+	for _, test := range tests {
+		s, rw, _ := newTestSim(t)
+		send(t, s, rw, test.cmds...)
+		if m := s.Mode(); m != test.want {
+			t.Errorf("%s: mode %v, want %v", test.name, m, test.want)
+		}
+		if test.want == sensors.Off {
+			// An Off robot doesn't answer sensor queries.
+			continue
+		}
+		if got := sensors.Mode(sensor(t, rw, sensors.PacketMode)[0]); got != test.want {
+			t.Errorf("%s: mode packet %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPassiveModeIgnoresActuators(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	send(t, s, rw, oi.Start{}, oi.DirectDrive{Right: 200, Left: 200}, oi.Motors{Bits: 0x07})
+	c.Advance(time.Second)
+	if p := s.Pose(); p != (Pose{}) {
+		t.Errorf("drove to %+v in Passive mode", p)
+	}
+	if m := s.Motors(); m != (Motors{}) {
+		t.Errorf("motors %+v in Passive mode", m)
+	}
+}
+
+func TestLeavingSafeModeStopsMotors(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	send(t, s, rw, oi.Start{}, oi.Safe{}, oi.DirectDrive{Right: 200, Left: 200}, oi.Motors{Bits: 0x07})
+	c.Advance(time.Second)
+	send(t, s, rw, oi.Start{})
+	if m := s.Motors(); m != (Motors{}) {
+		t.Errorf("motors %+v after returning to Passive mode", m)
+	}
+	c.Advance(time.Second)
+	if p := s.Pose(); p.X < 199 || p.X > 201 {
+		t.Errorf("drove to %+v, want to have stopped at X 200", p)
+	}
+}
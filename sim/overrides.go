@@ -0,0 +1,124 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cquinn/doombot/sensors"
+)
+
+// SensorStep is one step of a scripted sensor value: the value reported for
+// a packet, and for how long.
+type SensorStep struct {
+	Value    []byte
+	Duration time.Duration
+}
+
+// sensorOverride is a scripted value for one sensor packet. The steps run
+// back to back from start, after which the override expires. A zero
+// duration on the last step makes it last until cleared.
+type sensorOverride struct {
+	start time.Time
+	steps []SensorStep
+}
+
+// value returns the override's value at time now, and false once it has
+// expired.
+func (o *sensorOverride) value(now time.Time) ([]byte, bool) {
+	end := o.start
+	for i, step := range o.steps {
+		if step.Duration == 0 && i == len(o.steps)-1 {
+			return step.Value, true
+		}
+		end = end.Add(step.Duration)
+		if now.Before(end) {
+			return step.Value, true
+		}
+	}
+	return nil, false
+}
+
+// SetSensor makes the simulator report the given value for a sensor packet
+// until it is cleared, in place of its simulated or default value. Only
+// what the robot reports changes: for example a scripted wheel drop doesn't
+// trigger Safe mode's wheel drop check. Group packets report their members'
+// overrides.
+func (sim *RoombaSimulator) SetSensor(packetId byte, value []byte) error {
+	return sim.ScriptSensor(packetId, []SensorStep{{Value: value}})
+}
+
+// SetSensorFor overrides a sensor packet's value, as SetSensor does, for
+// the given time on the simulator's clock.
+func (sim *RoombaSimulator) SetSensorFor(packetId byte, value []byte, d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("sensor packet %d: duration must be positive, got %v", packetId, d)
+	}
+	return sim.ScriptSensor(packetId, []SensorStep{{Value: value, Duration: d}})
+}
+
+// ScriptSensor overrides a sensor packet with a sequence of values, starting
+// now, replacing any earlier override of the packet. The packet reverts to
+// its simulated value once the steps run out, unless the last step has a
+// zero duration, in which case its value holds until cleared. For example,
+// to bump the left bumper for 200ms:
+//
+//	sim.ScriptSensor(7, []sim.SensorStep{{[]byte{2}, 200 * time.Millisecond}})
+func (sim *RoombaSimulator) ScriptSensor(packetId byte, steps []SensorStep) error {
+	length, err := sensors.Length(packetId)
+	if _, group := sensors.Members(packetId); err != nil || group {
+		return fmt.Errorf("sensor packet %d can't be overridden", packetId)
+	}
+	if len(steps) == 0 {
+		return fmt.Errorf("sensor packet %d: no steps in script", packetId)
+	}
+	script := make([]SensorStep, len(steps))
+	for i, step := range steps {
+		if len(step.Value) != length {
+			return fmt.Errorf("sensor packet %d: step %d value is %d bytes, want %d",
+				packetId, i, len(step.Value), length)
+		}
+		if step.Duration < 0 || (step.Duration == 0 && i != len(steps)-1) {
+			return fmt.Errorf("sensor packet %d: step %d has bad duration %v",
+				packetId, i, step.Duration)
+		}
+		// Copy the value so the caller can't change it behind our back.
+		script[i] = SensorStep{append([]byte(nil), step.Value...), step.Duration}
+	}
+
+	sim.lock()
+	defer sim.mu.Unlock()
+	log.Printf("overriding sensor %d with %v", packetId, script)
+	sim.overrides[packetId] = &sensorOverride{start: sim.now(), steps: script}
+	return nil
+}
+
+// ClearSensor removes any override of a sensor packet, so that it reports
+// its simulated value again.
+func (sim *RoombaSimulator) ClearSensor(packetId byte) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	delete(sim.overrides, packetId)
+}
+
+// ClearSensors removes all sensor overrides.
+func (sim *RoombaSimulator) ClearSensors() {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.overrides = make(map[byte]*sensorOverride)
+}
+
+// overriddenSensorValue returns the overridden value of a sensor packet,
+// and false if it isn't overridden. Expired overrides are removed.
+func (sim *RoombaSimulator) overriddenSensorValue(packetId byte) ([]byte, bool) {
+	o, ok := sim.overrides[packetId]
+	if !ok {
+		return nil, false
+	}
+	value, ok := o.value(sim.now())
+	if !ok {
+		delete(sim.overrides, packetId)
+		return nil, false
+	}
+	return value, true
+}
@@ -0,0 +1,117 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+func TestSensorOverrides(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	send(t, s, rw, oi.Start{})
+	bumps := func() byte { return sensor(t, rw, sensors.PacketBumps)[0] }
+
+	if err := s.SetSensor(sensors.PacketBumps, []byte{0x01}); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(time.Hour)
+	if got := bumps(); got != 0x01 {
+		t.Errorf("bumps %#02x an hour after SetSensor, want 0x01", got)
+	}
+	s.ClearSensor(sensors.PacketBumps)
+	if got := bumps(); got != 0 {
+		t.Errorf("bumps %#02x after ClearSensor, want 0", got)
+	}
+
+	if err := s.SetSensorFor(sensors.PacketBumps, []byte{0x02}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(999 * time.Millisecond)
+	if got := bumps(); got != 0x02 {
+		t.Errorf("bumps %#02x before SetSensorFor expired, want 0x02", got)
+	}
+	c.Advance(time.Millisecond)
+	if got := bumps(); got != 0 {
+		t.Errorf("bumps %#02x after SetSensorFor expired, want 0", got)
+	}
+
+	if err := s.SetSensor(sensors.PacketBumps, []byte{0x01}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSensor(sensors.PacketWall, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	s.ClearSensors()
+	if got, wall := bumps(), sensor(t, rw, sensors.PacketWall)[0]; got != 0 || wall != 0 {
+		t.Errorf("bumps %#02x and wall %d after ClearSensors, want 0 and 0", got, wall)
+	}
+}
+
+func TestScriptSensor(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	send(t, s, rw, oi.Start{})
+	err := s.ScriptSensor(sensors.PacketBumps, []SensorStep{
+		{[]byte{0x01}, 100 * time.Millisecond},
+		{[]byte{0x02}, 200 * time.Millisecond},
+		{[]byte{0x03}, 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		advance time.Duration
+		want    byte
+	}{
+		{0, 0x01},
+		{99 * time.Millisecond, 0x01},
+		{time.Millisecond, 0x02},
+		{199 * time.Millisecond, 0x02},
+		{time.Millisecond, 0x03},
+		// The last step has no duration, so it holds.
+		{time.Hour, 0x03},
+	}
+	for i, step := range steps {
+		c.Advance(step.advance)
+		if got := sensor(t, rw, sensors.PacketBumps)[0]; got != step.want {
+			t.Errorf("step %d: bumps %#02x, want %#02x", i, got, step.want)
+		}
+	}
+
+	// A script that runs out reverts to the simulated value.
+	err = s.ScriptSensor(sensors.PacketBumps, []SensorStep{{[]byte{0x01}, time.Second}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(time.Second)
+	if got := sensor(t, rw, sensors.PacketBumps)[0]; got != 0 {
+		t.Errorf("bumps %#02x after the script ran out, want 0", got)
+	}
+}
+
+func TestSensorOverrideErrors(t *testing.T) {
+	s, _, _ := newTestSim(t)
+	tests := []struct {
+		name  string
+		id    byte
+		steps []SensorStep
+	}{
+		{"group", 0, []SensorStep{{Value: make([]byte, 26)}}},
+		{"unknown packet", 200, []SensorStep{{Value: []byte{0}}}},
+		{"short value", sensors.PacketVoltage, []SensorStep{{Value: []byte{0}}}},
+		{"long value", sensors.PacketBumps, []SensorStep{{Value: []byte{0, 0}}}},
+		{"no steps", sensors.PacketBumps, nil},
+		{"negative duration", sensors.PacketBumps, []SensorStep{{[]byte{0}, -time.Second}}},
+		{"zero duration before the last step", sensors.PacketBumps,
+			[]SensorStep{{[]byte{0}, 0}, {[]byte{1}, time.Second}}},
+	}
+	for _, test := range tests {
+		if err := s.ScriptSensor(test.id, test.steps); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+	if err := s.SetSensorFor(sensors.PacketBumps, []byte{0}, 0); err == nil {
+		t.Error("SetSensorFor with no duration: no error")
+	}
+}
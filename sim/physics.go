@@ -0,0 +1,191 @@
+package sim
+
+import (
+	"math"
+	"time"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+	"github.com/xa4a/go-roomba"
+)
+
+// Create 2 drive geometry, as published by iRobot.
+const (
+	WheelBase     = 235.0 // Distance between the drive wheels, in mm.
+	WheelDiameter = 72.0  // Drive wheel diameter, in mm.
+	CountsPerRev  = 508.8 // Encoder counts per drive wheel revolution.
+
+	maxWheelVelocity = 500.0 // mm/s, the OI clamps requests to this.
+
+	// The OI updates its internal state every 15ms, so the simulator
+	// integrates motion in steps no longer than that. While the robot is
+	// stationary only the battery changes, and slowly.
+	physicsStep = 15 * time.Millisecond
+	idleStep    = time.Second
+)
+
+// Pose is the position of the simulated robot in the world, in mm, and its
+// heading in radians counter-clockwise from the X axis.
+type Pose struct {
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Heading float64 `json:"heading"`
+}
+
+// Pose returns the current pose of the simulated robot.
+func (sim *RoombaSimulator) Pose() Pose {
+	sim.lock()
+	defer sim.mu.Unlock()
+	return sim.pose
+}
+
+// SetPose places the simulated robot at the given pose. Odometry is not
+// affected, as if the robot had been picked up and put down.
+func (sim *RoombaSimulator) SetPose(p Pose) {
+	sim.lock()
+	defer sim.mu.Unlock()
+	sim.pose = p
+	sim.publish()
+	sim.place(sim.lastUpdate)
+}
+
+func (sim *RoombaSimulator) now() time.Time {
+	return sim.clock.Now()
+}
+
+// setWheels brings the physics up to date and then changes the wheel
+// velocities, in mm/s.
+func (sim *RoombaSimulator) setWheels(right, left float64) {
+	sim.advance(sim.now())
+	sim.rightVelocity = clampVelocity(right)
+	sim.leftVelocity = clampVelocity(left)
+}
+
+// advance integrates the robot's motion from the last update up to now.
+func (sim *RoombaSimulator) advance(now time.Time) {
+	if sim.lastUpdate.IsZero() {
+		sim.lastUpdate = now
+	}
+	for sim.lastUpdate.Before(now) {
+		maxStep := idleStep
+		if sim.moving() || sim.seeking {
+			maxStep = physicsStep
+		}
+		dt := now.Sub(sim.lastUpdate)
+		if dt > maxStep {
+			dt = maxStep
+		}
+		sim.step(dt.Seconds())
+		sim.publish()
+		sim.lastUpdate = sim.lastUpdate.Add(dt)
+		sim.record(sim.lastUpdate)
+	}
+}
+
+// step simulates the robot for dt seconds.
+func (sim *RoombaSimulator) step(dt float64) {
+	if sim.seeking {
+		sim.seekDock()
+	}
+	if sim.moving() {
+		sim.drive(dt)
+	}
+	sim.updateBattery(dt)
+	sim.checkSafety()
+}
+
+func (sim *RoombaSimulator) moving() bool {
+	return sim.rightVelocity != 0 || sim.leftVelocity != 0
+}
+
+// drive moves the robot for dt seconds at the current wheel velocities.
+func (sim *RoombaSimulator) drive(dt float64) {
+	dl := sim.leftVelocity * dt
+	dr := sim.rightVelocity * dt
+	distance := (dr + dl) / 2
+	angle := (dr - dl) / WheelBase
+
+	// Midpoint integration is plenty accurate at 15ms steps.
+	heading := sim.pose.Heading + angle/2
+	sim.moveTo(Pose{
+		X:       sim.pose.X + distance*math.Cos(heading),
+		Y:       sim.pose.Y + distance*math.Sin(heading),
+		Heading: normalizeAngle(sim.pose.Heading + angle),
+	})
+
+	// The wheels keep turning when the robot is blocked, so odometry
+	// counts the commanded motion regardless.
+	sim.odoDistance += distance
+	sim.odoAngle += angle
+	sim.leftTravel += dl
+	sim.rightTravel += dr
+}
+
+// physicsSensorValue returns the value of the sensor packets derived from
+// the robot's motion, and false for any other packet.
+func (sim *RoombaSimulator) physicsSensorValue(packetId byte) ([]byte, bool) {
+	switch packetId {
+	case sensors.PacketDistance:
+		// The distance and angle are reset whenever they are read, but
+		// any fraction too small to report is carried over.
+		distance := clampInt16(math.Trunc(sim.odoDistance))
+		sim.odoDistance -= float64(distance)
+		return roomba.Pack([]interface{}{distance}), true
+	case sensors.PacketAngle:
+		degrees := clampInt16(math.Trunc(sim.odoAngle * 180 / math.Pi))
+		sim.odoAngle -= float64(degrees) * math.Pi / 180
+		return roomba.Pack([]interface{}{degrees}), true
+	case sensors.PacketRequestedRightVelocity:
+		return roomba.Pack([]interface{}{sim.requestedRight}), true
+	case sensors.PacketRequestedLeftVelocity:
+		return roomba.Pack([]interface{}{sim.requestedLeft}), true
+	case sensors.PacketLeftEncoderCounts:
+		return roomba.Pack([]interface{}{encoderCounts(sim.leftTravel)}), true
+	case sensors.PacketRightEncoderCounts:
+		return roomba.Pack([]interface{}{encoderCounts(sim.rightTravel)}), true
+	}
+	return nil, false
+}
+
+// driveWheelVelocities converts a Drive command's velocity and radius into
+// right and left wheel velocities.
+func driveWheelVelocities(velocity, radius int16) (right, left float64) {
+	v := float64(velocity)
+	switch radius {
+	case oi.StraightAlt, oi.Straight, 0:
+		// Straight. A zero radius is meaningless, but go-roomba's Stop()
+		// sends it along with a zero velocity.
+		return v, v
+	case oi.TurnCounterClock:
+		// Turn in place counter-clockwise.
+		return v, -v
+	case oi.TurnClockwise:
+		// Turn in place clockwise.
+		return -v, v
+	}
+	r := float64(radius)
+	return v * (r + WheelBase/2) / r, v * (r - WheelBase/2) / r
+}
+
+// encoderCounts converts the distance travelled by a wheel into the
+// cumulative encoder count, which rolls over at 65535.
+func encoderCounts(travel float64) uint16 {
+	return uint16(int64(math.Floor(travel * CountsPerRev / (math.Pi * WheelDiameter))))
+}
+
+func clampVelocity(v float64) float64 {
+	return math.Max(-maxWheelVelocity, math.Min(maxWheelVelocity, v))
+}
+
+func clampInt16(v float64) int16 {
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, v)))
+}
+
+// normalizeAngle wraps an angle in radians into [-Pi, Pi).
+func normalizeAngle(a float64) float64 {
+	a = math.Mod(a+math.Pi, 2*math.Pi)
+	if a < 0 {
+		a += 2 * math.Pi
+	}
+	return a - math.Pi
+}
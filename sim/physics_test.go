@@ -0,0 +1,95 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+func TestDriving(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  oi.Command
+		// The pose and odometry after a second, from a standing start at
+		// the origin facing along the X axis.
+		want           Pose
+		distance       int16 // mm
+		angle          int16 // degrees
+		left, right    uint16
+		requestedRight int16
+	}{
+		{"straight", oi.DirectDrive{Right: 200, Left: 200},
+			Pose{X: 200}, 200, 0, 449, 449, 200},
+		{"backwards", oi.Drive{Velocity: -100, Radius: oi.Straight},
+			Pose{X: -100}, -100, 0, 65311, 65311, 0},
+		{"straight alt", oi.Drive{Velocity: 100, Radius: oi.StraightAlt},
+			Pose{X: 100}, 100, 0, 224, 224, 0},
+		// 200mm/s around the circumference of the wheel base's circle.
+		{"spin", oi.Drive{Velocity: 200, Radius: oi.TurnCounterClock},
+			Pose{Heading: 400.0 / 235}, 0, 97, 65086, 449, 0},
+		{"spin clockwise", oi.Drive{Velocity: 200, Radius: oi.TurnClockwise},
+			Pose{Heading: -400.0 / 235}, 0, -97, 449, 65086, 0},
+		// An arc of 0.4 radians of a circle of radius 500mm.
+		{"arc", oi.Drive{Velocity: 200, Radius: 500},
+			Pose{X: 500 * math.Sin(0.4), Y: 500 * (1 - math.Cos(0.4)), Heading: 0.4}, 200, 22, 344, 555, 0},
+		{"direct arc", oi.DirectDrive{Right: 247, Left: 153},
+			Pose{X: 500 * math.Sin(0.4), Y: 500 * (1 - math.Cos(0.4)), Heading: 0.4}, 200, 22, 344, 555, 247},
+	}
+	for _, test := range tests {
+		s, rw, c := newTestSim(t)
+		send(t, s, rw, oi.Start{}, oi.Safe{}, test.cmd)
+		c.Advance(time.Second)
+		if got := sensor16(t, rw, sensors.PacketRequestedRightVelocity); got != test.requestedRight {
+			t.Errorf("%s: requested right velocity %d, want %d", test.name, got, test.requestedRight)
+		}
+		send(t, s, rw, oi.DirectDrive{})
+		c.Advance(time.Second)
+
+		p := s.Pose()
+		if math.Hypot(p.X-test.want.X, p.Y-test.want.Y) > 1 || math.Abs(normalizeAngle(p.Heading-test.want.Heading)) > 0.01 {
+			t.Errorf("%s: pose %+v, want %+v", test.name, p, test.want)
+		}
+		if got := sensor16(t, rw, sensors.PacketDistance); got != test.distance {
+			t.Errorf("%s: distance %d, want %d", test.name, got, test.distance)
+		}
+		if got := sensor16(t, rw, sensors.PacketAngle); got != test.angle {
+			t.Errorf("%s: angle %d, want %d", test.name, got, test.angle)
+		}
+		left := uint16(sensor16(t, rw, sensors.PacketLeftEncoderCounts))
+		right := uint16(sensor16(t, rw, sensors.PacketRightEncoderCounts))
+		if left != test.left || right != test.right {
+			t.Errorf("%s: encoders %d, %d, want %d, %d", test.name, left, right, test.left, test.right)
+		}
+	}
+}
+
+func TestOdometryCarriesFractions(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	send(t, s, rw, oi.Start{}, oi.Safe{}, oi.DirectDrive{Right: 201, Left: 201})
+	// 100.5mm, of which the packet reports 100 and keeps the half.
+	c.Advance(500 * time.Millisecond)
+	if got := sensor16(t, rw, sensors.PacketDistance); got != 100 {
+		t.Errorf("distance %d, want 100", got)
+	}
+	c.Advance(500 * time.Millisecond)
+	if got := sensor16(t, rw, sensors.PacketDistance); got != 101 {
+		t.Errorf("distance %d, want 101", got)
+	}
+}
+
+func TestWheelVelocityLimit(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	send(t, s, rw, oi.Start{}, oi.Full{})
+	// The encoder refuses 600mm/s, but a client could still send it.
+	sendBytes(t, s, rw, oi.OpDirectDrive, 0x02, 0x58, 0x02, 0x58)
+	c.Advance(time.Second)
+	if p := s.Pose(); math.Abs(p.X-500) > 1 {
+		t.Errorf("drove to %+v, want X 500", p)
+	}
+	if got := sensor16(t, rw, sensors.PacketRequestedRightVelocity); got != 600 {
+		t.Errorf("requested right velocity %d, want 600", got)
+	}
+}
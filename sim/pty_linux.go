@@ -0,0 +1,118 @@
+package sim
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/cquinn/doombot/clock"
+)
+
+// ServeRoombaSimPty starts a simulator on a new pseudo-terminal, and returns
+// the path of the pty's slave device. Clients open that path like a serial
+// port, so the serial code that talks to a real robot can be tested against
+// the simulator. The pty is closed when the simulator stops.
+func ServeRoombaSimPty() (*RoombaSimulator, string, error) {
+	master, slavePath, err := openPty()
+	if err != nil {
+		return nil, "", err
+	}
+	// Hold the slave open ourselves. Otherwise reads on the master fail as
+	// soon as a client closes the port, and the simulator would stop
+	// between one client and the next.
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	if err := makeRaw(slave); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, "", err
+	}
+	sim := serveRoombaSim(master, clock.Real{}, master, slave)
+	// The client sets the pty's baud rate when it opens it, as it would a
+	// real serial port, so mismatches can be caught.
+	sim.link.mu.Lock()
+	sim.link.detectHostBaud = func() (int, bool) { return ptyBaud(slave) }
+	sim.link.mu.Unlock()
+	return sim, slavePath, nil
+}
+
+// openPty opens a new pseudo-terminal master and unlocks its slave, as
+// posix_openpt, grantpt and unlockpt do.
+func openPty() (*os.File, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, "", fmt.Errorf("unlockpt: %v", err)
+	}
+	var n uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, "", fmt.Errorf("ptsname: %v", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n), nil
+}
+
+// makeRaw puts a terminal into raw mode, as cfmakeraw does, so that the OI's
+// binary bytes pass through without being echoed or translated.
+func makeRaw(f *os.File) error {
+	var t syscall.Termios
+	if err := ioctl(f.Fd(), syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		return fmt.Errorf("tcgetattr: %v", err)
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if err := ioctl(f.Fd(), syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		return fmt.Errorf("tcsetattr: %v", err)
+	}
+	return nil
+}
+
+// cbaud masks the speed bits of a termios Cflag. The syscall package lacks
+// it.
+const cbaud = 0x100f
+
+// termiosBauds maps the termios speed constants to baud rates.
+var termiosBauds = map[uint32]int{
+	syscall.B300:    300,
+	syscall.B600:    600,
+	syscall.B1200:   1200,
+	syscall.B2400:   2400,
+	syscall.B4800:   4800,
+	syscall.B9600:   9600,
+	syscall.B19200:  19200,
+	syscall.B38400:  38400,
+	syscall.B57600:  57600,
+	syscall.B115200: 115200,
+}
+
+// ptyBaud returns the baud rate a terminal is set to.
+func ptyBaud(f *os.File) (int, bool) {
+	var t syscall.Termios
+	if err := ioctl(f.Fd(), syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		return 0, false
+	}
+	baud, ok := termiosBauds[t.Cflag&cbaud]
+	return baud, ok
+}
+
+func ioctl(fd uintptr, req uint, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package sim
+
+import (
+	"errors"
+	"runtime"
+)
+
+// ServeRoombaSimPty is only supported on Linux.
+func ServeRoombaSimPty() (*RoombaSimulator, string, error) {
+	return nil, "", errors.New("pty simulator not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,311 @@
+package sim
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+const (
+	// renderSize is the size in pixels of the longer side of the world in
+	// a rendered image.
+	renderSize   = 800
+	renderMargin = 10 // pixels around the world
+	// The margin in mm around the robot's path, when there's no world to
+	// frame the picture.
+	pathMargin = 500.0
+
+	gifFrameDelay = 10 // 100ths of a second
+)
+
+// Colors of the rendered images, as indexes into renderPalette.
+const (
+	colorOutside uint8 = iota
+	colorFloor
+	colorObstacle
+	colorDrop
+	colorDock
+	colorPath
+	colorPlaced
+	colorBump
+	colorRobot
+	colorOtherRobot
+)
+
+var renderPalette = color.Palette{
+	colorOutside:    color.RGBA{0x60, 0x60, 0x60, 0xff},
+	colorFloor:      color.RGBA{0xff, 0xff, 0xff, 0xff},
+	colorObstacle:   color.RGBA{0x30, 0x30, 0x30, 0xff},
+	colorDrop:       color.RGBA{0x90, 0xc0, 0xf0, 0xff},
+	colorDock:       color.RGBA{0x00, 0xa0, 0x00, 0xff},
+	colorPath:       color.RGBA{0x20, 0x40, 0xe0, 0xff},
+	colorPlaced:     color.RGBA{0xc0, 0xc0, 0xc0, 0xff},
+	colorBump:       color.RGBA{0xe0, 0x00, 0x00, 0xff},
+	colorRobot:      color.RGBA{0xff, 0x80, 0x00, 0xff},
+	colorOtherRobot: color.RGBA{0xa0, 0x00, 0xc0, 0xff},
+}
+
+// canvas maps world coordinates onto a paletted image. The world's Y axis
+// points up, so it is flipped.
+type canvas struct {
+	*image.Paletted
+	minX, maxY, scale float64
+}
+
+// newCanvas returns a blank canvas framing the run's world or, with no
+// world, its path.
+func (r Run) newCanvas() *canvas {
+	minX, minY, maxX, maxY := 0.0, 0.0, 0.0, 0.0
+	if r.World != nil {
+		maxX, maxY = r.World.Width, r.World.Height
+	} else {
+		minX, minY = math.Inf(1), math.Inf(1)
+		maxX, maxY = math.Inf(-1), math.Inf(-1)
+		for _, s := range r.Path {
+			minX, maxX = math.Min(minX, s.Pose.X), math.Max(maxX, s.Pose.X)
+			minY, maxY = math.Min(minY, s.Pose.Y), math.Max(maxY, s.Pose.Y)
+		}
+		if len(r.Path) == 0 {
+			minX, minY, maxX, maxY = 0, 0, 0, 0
+		}
+		minX, minY = minX-pathMargin, minY-pathMargin
+		maxX, maxY = maxX+pathMargin, maxY+pathMargin
+	}
+	scale := renderSize / math.Max(maxX-minX, maxY-minY)
+	w := int(math.Ceil((maxX-minX)*scale)) + 2*renderMargin
+	h := int(math.Ceil((maxY-minY)*scale)) + 2*renderMargin
+	return &canvas{
+		Paletted: image.NewPaletted(image.Rect(0, 0, w, h), renderPalette),
+		minX:     minX,
+		maxY:     maxY,
+		scale:    scale,
+	}
+}
+
+// pixel returns the position of a world point on the canvas.
+func (c *canvas) pixel(p Point) (float64, float64) {
+	return (p.X-c.minX)*c.scale + renderMargin, (c.maxY-p.Y)*c.scale + renderMargin
+}
+
+func (c *canvas) plot(x, y float64, ci uint8) {
+	c.SetColorIndex(int(math.Floor(x)), int(math.Floor(y)), ci)
+}
+
+// line draws a line between two world points.
+func (c *canvas) line(a, b Point, ci uint8) {
+	x0, y0 := c.pixel(a)
+	x1, y1 := c.pixel(b)
+	n := math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0)))
+	for i := 0.0; i <= n; i++ {
+		t := 0.0
+		if n > 0 {
+			t = i / n
+		}
+		c.plot(x0+(x1-x0)*t, y0+(y1-y0)*t, ci)
+	}
+}
+
+// circle draws the outline of a circle of radius r mm.
+func (c *canvas) circle(center Point, r float64, ci uint8) {
+	x0, y0 := c.pixel(center)
+	r *= c.scale
+	n := math.Max(8, math.Ceil(2*math.Pi*r))
+	for i := 0.0; i < n; i++ {
+		a := 2 * math.Pi * i / n
+		c.plot(x0+r*math.Cos(a), y0+r*math.Sin(a), ci)
+	}
+}
+
+// dot draws a square a few pixels across, visible at any scale.
+func (c *canvas) dot(p Point, ci uint8) {
+	x0, y0 := c.pixel(p)
+	for dy := -2.0; dy <= 2; dy++ {
+		for dx := -2.0; dx <= 2; dx++ {
+			c.plot(x0+dx, y0+dy, ci)
+		}
+	}
+}
+
+// fill fills a polygon, by scanning each row of pixels for the edges it
+// crosses.
+func (c *canvas) fill(p Polygon, ci uint8) {
+	xs := make([]float64, len(p))
+	ys := make([]float64, len(p))
+	for i, v := range p {
+		xs[i], ys[i] = c.pixel(v)
+	}
+	b := c.Bounds()
+	for py := b.Min.Y; py < b.Max.Y; py++ {
+		y := float64(py) + 0.5
+		var crossings []float64
+		for i := range p {
+			j := (i + 1) % len(p)
+			if (ys[i] <= y) != (ys[j] <= y) {
+				crossings = append(crossings, xs[i]+(y-ys[i])/(ys[j]-ys[i])*(xs[j]-xs[i]))
+			}
+		}
+		sort.Float64s(crossings)
+		for k := 0; k+1 < len(crossings); k += 2 {
+			for px := math.Ceil(crossings[k] - 0.5); px+0.5 < crossings[k+1]; px++ {
+				c.SetColorIndex(int(px), py, ci)
+			}
+		}
+	}
+}
+
+// drawWorld draws the floor, drops, obstacles and dock.
+func (c *canvas) drawWorld(w *World) {
+	for i := range c.Pix {
+		c.Pix[i] = colorFloor
+	}
+	if w == nil {
+		return
+	}
+	// Everything beyond the walls is outside the world.
+	b := c.Bounds()
+	left, top := c.pixel(Point{0, w.Height})
+	right, bottom := c.pixel(Point{w.Width, 0})
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			fx, fy := float64(x)+0.5, float64(y)+0.5
+			if fx < left || fx > right || fy < top || fy > bottom {
+				c.SetColorIndex(x, y, colorOutside)
+			}
+		}
+	}
+	for _, p := range w.Drops {
+		c.fill(p, colorDrop)
+	}
+	for _, p := range w.Obstacles {
+		c.fill(p, colorObstacle)
+	}
+	if d := w.Dock; d != nil {
+		// The dock's back, and an arrow out along its heading.
+		p := d.Pose
+		c.line(p.offset(dockedOffset, math.Pi/2), p.offset(dockedOffset, -math.Pi/2), colorDock)
+		c.line(p.point(), p.offset(dockedOffset, 0), colorDock)
+		c.dot(p.point(), colorDock)
+	}
+}
+
+// drawStep draws the path from one sample to the next.
+func (c *canvas) drawStep(from, to Sample) {
+	if to.Placed {
+		c.line(from.Pose.point(), to.Pose.point(), colorPlaced)
+		return
+	}
+	c.line(from.Pose.point(), to.Pose.point(), colorPath)
+}
+
+// drawBump marks where the bumper was pressed.
+func (c *canvas) drawBump(b BumpEvent) {
+	bearing := 0.0
+	switch {
+	case b.Left && !b.Right:
+		bearing = bumpCenterAngle * 2
+	case b.Right && !b.Left:
+		bearing = -bumpCenterAngle * 2
+	}
+	c.dot(b.Pose.offset(RobotRadius, bearing), colorBump)
+}
+
+// drawRobot draws a robot's footprint and heading.
+func (c *canvas) drawRobot(p Pose, ci uint8) {
+	c.circle(p.point(), RobotRadius, ci)
+	c.line(p.point(), p.offset(RobotRadius, 0), ci)
+}
+
+// Image draws the run from above: the world, the robot's path, the places
+// it bumped into things, and the robot and any others in its world where
+// the run ends.
+func (r Run) Image() *image.Paletted {
+	c := r.newCanvas()
+	c.drawWorld(r.World)
+	for i := 1; i < len(r.Path); i++ {
+		c.drawStep(r.Path[i-1], r.Path[i])
+	}
+	for _, b := range r.Bumps {
+		c.drawBump(b)
+	}
+	for _, p := range r.Robots {
+		c.drawRobot(p, colorOtherRobot)
+	}
+	if n := len(r.Path); n > 0 {
+		c.drawRobot(r.Path[n-1].Pose, colorRobot)
+	}
+	return c.Paletted
+}
+
+// WritePNG writes the run's Image as a PNG.
+func (r Run) WritePNG(w io.Writer) error {
+	return png.Encode(w, r.Image())
+}
+
+// WriteGIF writes an animation of the run as a GIF, with the given number
+// of frames evenly spaced through it.
+func (r Run) WriteGIF(w io.Writer, frames int) error {
+	if frames < 1 {
+		frames = 1
+	}
+	trail := r.newCanvas()
+	trail.drawWorld(r.World)
+	anim := &gif.GIF{}
+	var start, end int64
+	if n := len(r.Path); n > 0 {
+		start, end = r.Path[0].Time.UnixNano(), r.Path[n-1].Time.UnixNano()
+	}
+	next, nextBump := 1, 0
+	for f := 0; f < frames; f++ {
+		t := end
+		if frames > 1 {
+			t = start + (end-start)*int64(f)/int64(frames-1)
+		}
+		// Add the path up to this frame's time to the trail, and draw the
+		// robot on a copy of it.
+		for ; next < len(r.Path) && r.Path[next].Time.UnixNano() <= t; next++ {
+			trail.drawStep(r.Path[next-1], r.Path[next])
+		}
+		for ; nextBump < len(r.Bumps) && r.Bumps[nextBump].Time.UnixNano() <= t; nextBump++ {
+			trail.drawBump(r.Bumps[nextBump])
+		}
+		frame := *trail
+		frame.Paletted = image.NewPaletted(trail.Bounds(), renderPalette)
+		copy(frame.Pix, trail.Pix)
+		if next > 0 && next <= len(r.Path) {
+			frame.drawRobot(r.Path[next-1].Pose, colorRobot)
+		}
+		anim.Image = append(anim.Image, frame.Paletted)
+		anim.Delay = append(anim.Delay, gifFrameDelay)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// SavePNG writes the run's Image to the named PNG file.
+func (r Run) SavePNG(path string) error {
+	return r.save(path, r.WritePNG)
+}
+
+// SaveGIF writes an animation of the run, in the given number of frames, to
+// the named GIF file.
+func (r Run) SaveGIF(path string, frames int) error {
+	return r.save(path, func(w io.Writer) error {
+		return r.WriteGIF(w, frames)
+	})
+}
+
+func (r Run) save(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -12,185 +12,533 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/xa4a/go-roomba"
-	"github.com/xa4a/go-roomba/constants"
+	"github.com/cquinn/doombot/clock"
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
 )
 
 // Roomba simulator instance. Should be constructed with MakeRoombaSim()
 // function.
 type RoombaSimulator struct {
-	rw           io.ReadWriter
-	writeQ       chan []byte
-	WrittenBytes bytes.Buffer // Logs all the bytes written by the simulator to its Writer.
-	ReadBytes    bytes.Buffer // Logs all the bytes read by the simulator from its Reader.
+	rw        io.ReadWriter
+	link      *link
+	clock     clock.Clock
+	writeQ    chan []byte
+	quit      chan struct{}
+	stopOnce  sync.Once
+	ReadBytes bytes.Buffer // Logs all the bytes read by the simulator from its Reader.
 
 	RequestedVelocity []byte
 	RequestedRadius   []byte
+
+	// Lifecycle: Stop closes the connection with closers, and Close waits
+	// for the goroutines in wg.
+	closers []io.Closer
+	wg      sync.WaitGroup
+
+	// Progress through the client's commands, for WaitForCommands.
+	sent     *countingWriter // bytes written by MakeRoombaSim's client
+	executed int64           // bytes received by completed commands
+	cmdDone  *sync.Cond      // signalled with mu as each command completes
+
+	// The bytes of the command being executed, for the command log.
+	cmdBytes []byte
+
+	// mu guards the simulated robot state below, which is shared between
+	// the command loop and the exported accessors.
+	mu             sync.Mutex
+	mode           sensors.Mode
+	battery        battery
+	world          *World
+	seeking        bool // running the Seek Dock behavior
+	homing         bool // seeking, and the dock's beams have been found
+	finalApproach  bool // homing, and lined up in front of the dock
+	songs          [oi.SongSlots]Song
+	leds           LEDs
+	digits         [4]byte
+	digitText      string
+	motors         Motors
+	cleaning       CleaningMode
+	buttons        byte
+	buttonsRel     time.Time // when the pressed buttons release
+	asleep         bool
+	songNumber     int
+	songEnd        time.Time
+	errors         []error
+	err            error // why the simulator stopped
+	streamPackets  []byte
+	streamPaused   bool
+	sensorDefaults map[byte][]byte // values of the sensors that aren't modelled
+	overrides      map[byte]*sensorOverride
+	unmocked       map[byte]bool // packets already logged as having no value
+	pose           Pose
+	lastUpdate     time.Time
+	rightVelocity  float64 // mm/s
+	leftVelocity   float64 // mm/s
+	requestedRight int16
+	requestedLeft  int16
+	odoDistance    float64 // mm travelled since distance was last read
+	odoAngle       float64 // radians turned since angle was last read
+	leftTravel     float64 // mm travelled by the left wheel
+	rightTravel    float64 // mm travelled by the right wheel
+	commands       []LoggedCommand
+	recording      recording
+	beacon         byte // the IR character sent to other robots in the world
 }
 
-// MockSensorValues contains mapping of sensor codes to sensor values returned
-// by a RoombaSimulator object on sensor requests.
+// MockSensorValues contains the default values of the sensors the simulator
+// doesn't model. Each RoombaSimulator takes a copy when it is made, so
+// changing the map only affects simulators made afterwards; use SetSensor
+// to change a running simulator's values.
 var MockSensorValues = map[byte][]byte{
-	constants.SENSOR_BUMP_WHEELS_DROPS: []byte{0},
-	constants.SENSOR_VIRTUAL_WALL:      []byte{5},
-	constants.SENSOR_CLIFF_RIGHT:       []byte{42},
-	constants.SENSOR_CHARGING:          []byte{21},
-	constants.SENSOR_VOLTAGE:           roomba.Pack([]interface{}{uint16(1200)}),
-	// constants.SENSOR_CURRENT:           []byte{23},
-	constants.SENSOR_TEMPERATURE: []byte{24},
-	// constants.SENSOR_BATTERY_CHARGE:          []byte{25},
-	constants.SENSOR_OI_MODE:                 []byte{2},
-	constants.SENSOR_SONG_NUMBER:             []byte{1},
-	constants.SENSOR_DISTANCE:                []byte{10, 20},
-	constants.SENSOR_WALL:                    []byte{35},
-	constants.SENSOR_BATTERY_CHARGE:          roomba.Pack([]interface{}{uint16(1300)}),
-	constants.SENSOR_BATTERY_CAPACITY:        roomba.Pack([]interface{}{uint16(1500)}),
-	constants.SENSOR_CURRENT:                 roomba.Pack([]interface{}{int16(-747)}),
-	constants.SENSOR_CLIFF_FRONT_LEFT_SIGNAL: roomba.Pack([]interface{}{uint8(2), uint8(25)}),
+	sensors.PacketVirtualWall: []byte{5},
+	sensors.PacketTemperature: []byte{24},
 }
 
 func (sim *RoombaSimulator) serve() {
+	defer sim.wg.Done()
+
 	// Write bytes from channel asynchronously.
+	sim.wg.Add(1)
 	go func() {
+		defer sim.wg.Done()
 		for {
-			bs := <-sim.writeQ
-			if len(bs) == 0 {
-				break
+			select {
+			case bs := <-sim.writeQ:
+				sim.rw.Write(bs)
+			case <-sim.quit:
+				return
 			}
-			sim.rw.Write(bs)
 		}
 	}()
 
 	for {
-		sim.executeCMD()
+		if err := sim.executeCMD(); err != nil {
+			// Nothing more can be read, so the client has gone, or the
+			// simulator has been stopped.
+			select {
+			case <-sim.quit:
+			default:
+				log.Printf("RoombaSimulator stopping: %v", err)
+				if err != io.EOF {
+					sim.mu.Lock()
+					sim.err = err
+					sim.mu.Unlock()
+				}
+			}
+			sim.Stop()
+			return
+		}
+		if received, ok := sim.link.caughtUp(); ok {
+			sim.mu.Lock()
+			sim.executed = received
+			sim.cmdDone.Broadcast()
+			sim.mu.Unlock()
+		}
 	}
 }
 
+// Stop stops the simulator and closes its connection, which makes its
+// goroutines exit: one blocked reading a command only returns once the
+// connection is closed. It doesn't wait for them; Close does. It is safe to
+// call more than once.
 func (sim *RoombaSimulator) Stop() {
-	sim.writeQ <- []byte{}
-}
-
-func (sim *RoombaSimulator) executeCMD() error {
-	cmdBuf := sim.read(1)
-	if len(cmdBuf) != 1 {
-		return fmt.Errorf("failed reading opcode")
-	}
-	switch cmdBuf[0] {
-	case constants.OpCodes["Sensors"]:
-		packetId := sim.read(1)[0]
-		value, ok := MockSensorValues[packetId]
-		if !ok {
-			if packetId == constants.SENSOR_REQUESTED_RADIUS {
-				value = sim.RequestedRadius
-			} else if packetId == constants.SENSOR_REQUESTED_VELOCITY {
-				value = sim.RequestedVelocity
-			} else {
-				log.Printf("no mock value for sensor packet id %d", packetId)
-			}
+	sim.stopOnce.Do(func() {
+		close(sim.quit)
+		sim.mu.Lock()
+		sim.cmdDone.Broadcast()
+		sim.mu.Unlock()
+		for _, c := range sim.closers {
+			c.Close()
+		}
+	})
+}
+
+// Close stops the simulator, closing its connection, and waits for all of
+// its goroutines to exit.
+func (sim *RoombaSimulator) Close() error {
+	sim.Stop()
+	sim.wg.Wait()
+	return nil
+}
+
+// Err returns the error that stopped the simulator, such as a connection
+// closing in the middle of a command. It is nil while the simulator is
+// running, and if it was stopped by Stop or Close or by its client hanging
+// up between commands.
+func (sim *RoombaSimulator) Err() error {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.err
+}
+
+// WaitForCommands waits until the simulator has executed every command its
+// client has written, so that a test on a virtual clock can advance time
+// knowing the robot has seen them. It only applies to simulators made by
+// MakeRoombaSim. A command left incomplete, for example by bytes lost to
+// Faults or garbled by a baud mismatch, makes it wait until Stop.
+func (sim *RoombaSimulator) WaitForCommands() {
+	if sim.sent == nil {
+		return
+	}
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	for sim.executed < atomic.LoadInt64(&sim.sent.n) {
+		select {
+		case <-sim.quit:
+			return
+		default:
+		}
+		sim.cmdDone.Wait()
+	}
+}
+
+// Done returns a channel that is closed when the simulator stops, either
+// because Stop or Close was called or because its connection was closed.
+func (sim *RoombaSimulator) Done() <-chan struct{} {
+	return sim.quit
+}
+
+// executeCMD reads and executes a single command. It returns io.EOF if the
+// connection closes cleanly between commands, and any other error if reading
+// fails.
+func (sim *RoombaSimulator) executeCMD() (err error) {
+	sim.cmdBytes = sim.cmdBytes[:0]
+	opcode, err := sim.readByte()
+	if err != nil {
+		return err
+	}
+	// Once there's an opcode, running out of input is no longer clean.
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			err = fmt.Errorf("reading arguments of opcode %d: %w", opcode, err)
+		}
+	}()
+
+	// Until it is started, or woken after powering down, the OI ignores
+	// everything else it is sent.
+	sim.mu.Lock()
+	off := sim.mode == sensors.Off || sim.asleep
+	sim.mu.Unlock()
+	if off && opcode != oi.OpStart && opcode != oi.OpReset {
+		log.Printf("ignoring opcode %d while off or asleep", opcode)
+		return sim.skipArgs(opcode)
+	}
+	at := sim.now()
+	defer func() {
+		if err == nil {
+			sim.logCommand(at)
+		}
+	}()
+
+	switch opcode {
+	case oi.OpSensors:
+		packetId, err := sim.readByte()
+		if err != nil {
+			return err
 		}
+		value, _ := sim.sensorValue(packetId)
 		log.Printf("sensor %d value: %v", packetId, value)
 		sim.write(value)
-	case constants.OpCodes["QueryList"]:
-		nPackets := sim.read(1)[0]
+	case oi.OpQueryList:
+		nPackets, err := sim.readByte()
+		if err != nil {
+			return err
+		}
 		for i := 0; i < int(nPackets); i++ {
-			packetId := sim.read(1)[0]
-			value, ok := MockSensorValues[packetId]
-			if !ok {
-				if packetId == constants.SENSOR_REQUESTED_RADIUS {
-					value = sim.RequestedRadius
-				} else if packetId == constants.SENSOR_REQUESTED_VELOCITY {
-					value = sim.RequestedVelocity
-				} else {
-					log.Printf("no mock value for sensor packet id %d", packetId)
-				}
+			packetId, err := sim.readByte()
+			if err != nil {
+				return err
 			}
+			value, _ := sim.sensorValue(packetId)
 			log.Printf("sensor %d value: %v", packetId, value)
 			sim.write(value)
 		}
-	case constants.OpCodes["Stream"]:
-		nBytes := sim.read(1)[0]
-		packetIds := make([]byte, nBytes)
-		for i := byte(0); i < nBytes; i++ {
-			packetIds[i] = sim.read(1)[0]
-		}
-		// Contains just packet ids and values, no headers.
-		sensorValues := bytes.Buffer{}
-		for i := byte(0); i < nBytes; i++ {
-			mockValue, ok := MockSensorValues[packetIds[i]]
-			if !ok {
-				log.Printf("no mock value for streaming packet id: %d", packetIds[i])
-				mockValue = make([]byte, constants.SENSOR_PACKET_LENGTH[packetIds[i]])
-			} else {
-				log.Printf("sensor %d value: %v", packetIds[i], mockValue)
-			}
-			sensorValues.WriteByte(packetIds[i])
-			sensorValues.Write(mockValue)
-		}
-
-		output := bytes.Buffer{}
-		// Header.
-		output.WriteByte(19)
-		// Data length.
-		messageLen := byte(sensorValues.Len())
-		log.Printf("message length: %d", messageLen)
-		output.WriteByte(messageLen)
-		output.Write(sensorValues.Bytes())
-		checksum := byte(0)
-		for _, b := range output.Bytes()[1:] {
-			checksum -= b
-		}
-		output.WriteByte(checksum)
-		log.Printf("checksum: %d", checksum)
-
-		sim.write(output.Bytes())
-	case constants.OpCodes["Start"]:
-		log.Printf("switched to passive mode")
-	case constants.OpCodes["Safe"]:
-		log.Printf("switched to safe mode")
-	case constants.OpCodes["ResumeStream"]:
-		if sim.read(1)[0] == byte(0) {
-			log.Printf("stream paused")
+	case oi.OpStream:
+		nBytes, err := sim.readByte()
+		if err != nil {
+			return err
+		}
+		packetIds, err := sim.read(int(nBytes))
+		if err != nil {
+			return err
+		}
+		sim.startStream(packetIds)
+	case oi.OpSong:
+		header, err := sim.read(2)
+		if err != nil {
+			return err
+		}
+		num, length := header[0], header[1]
+		notes, err := sim.read(2 * int(length))
+		if err != nil {
+			return err
+		}
+		song := make(Song, len(notes)/2)
+		for i := range song {
+			song[i] = Note{notes[2*i], notes[2*i+1]}
+		}
+		sim.defineSong(num, song)
+	case oi.OpPlay:
+		num, err := sim.readByte()
+		if err != nil {
+			return err
+		}
+		sim.playSong(num)
+	case oi.OpStart:
+		sim.changeMode(sensors.Passive)
+	case oi.OpReset:
+		sim.reset()
+	case oi.OpBaud:
+		code, err := sim.readByte()
+		if err != nil {
+			return err
+		}
+		sim.setBaud(code)
+	case oi.OpPower:
+		sim.powerDown()
+	case oi.OpClean:
+		sim.startCleaning(CleaningClean)
+	case oi.OpSpot:
+		sim.startCleaning(CleaningSpot)
+	case oi.OpMax:
+		sim.startCleaning(CleaningMax)
+	case oi.OpLEDs:
+		data, err := sim.read(3)
+		if err != nil {
+			return err
+		}
+		sim.setLEDs(data[0], data[1], data[2])
+	case oi.OpDigitLEDsRaw, oi.OpDigitLEDsASCII:
+		data, err := sim.read(4)
+		if err != nil {
+			return err
+		}
+		var digits [4]byte
+		copy(digits[:], data)
+		if opcode == oi.OpDigitLEDsRaw {
+			sim.setDigitsRaw(digits)
 		} else {
-			log.Printf("stream resumed")
+			sim.setDigitsASCII(digits)
+		}
+	case oi.OpMotors:
+		bits, err := sim.readByte()
+		if err != nil {
+			return err
+		}
+		sim.setMotors(bits)
+	case oi.OpPWMMotors:
+		data, err := sim.read(3)
+		if err != nil {
+			return err
+		}
+		sim.setPWMMotors(int8(data[0]), int8(data[1]), data[2])
+	case oi.OpButtons:
+		bits, err := sim.readByte()
+		if err != nil {
+			return err
+		}
+		sim.pressButtons(bits)
+	case oi.OpSeekDock:
+		sim.startSeekingDock()
+	case oi.OpSafe, oi.OpControl:
+		sim.changeMode(sensors.Safe)
+	case oi.OpFull:
+		sim.changeMode(sensors.Full)
+	case oi.OpStop:
+		sim.changeMode(sensors.Off)
+		sim.startStream(nil)
+	case oi.OpPauseStream:
+		arg, err := sim.readByte()
+		if err != nil {
+			return err
+		}
+		sim.pauseStream(arg == byte(0))
+	case oi.OpDirectDrive:
+		data, err := sim.read(4)
+		if err != nil {
+			return err
 		}
-	case constants.OpCodes["DirectDrive"]:
-		data := sim.read(4)
 		var rigthVelocity, leftVelocity int16
 		binary.Read(bytes.NewReader(data[:2]), binary.BigEndian, &rigthVelocity)
 		binary.Read(bytes.NewReader(data[2:4]), binary.BigEndian, &leftVelocity)
 		log.Printf("DirectDrive: %d, %d (%v)", rigthVelocity, leftVelocity, data)
-	case constants.OpCodes["Drive"]:
-		sim.RequestedVelocity = sim.read(2)
-		sim.RequestedRadius = sim.read(2)
-		log.Printf("Drive: %d, %d", sim.RequestedVelocity, sim.RequestedRadius)
+		sim.actuate("DirectDrive", func() {
+			sim.requestedRight, sim.requestedLeft = rigthVelocity, leftVelocity
+			sim.setWheels(float64(rigthVelocity), float64(leftVelocity))
+		})
+	case oi.OpDrive:
+		data, err := sim.read(4)
+		if err != nil {
+			return err
+		}
+		velocityBytes, radiusBytes := data[:2], data[2:]
+		var velocity, radius int16
+		binary.Read(bytes.NewReader(velocityBytes), binary.BigEndian, &velocity)
+		binary.Read(bytes.NewReader(radiusBytes), binary.BigEndian, &radius)
+		log.Printf("Drive: %d, %d", velocity, radius)
+		sim.actuate("Drive", func() {
+			sim.RequestedVelocity = velocityBytes
+			sim.RequestedRadius = radiusBytes
+			sim.setWheels(driveWheelVelocities(velocity, radius))
+		})
 	default:
-		log.Printf("unknown opcode: %d", cmdBuf[0])
+		log.Printf("unknown opcode: %d", opcode)
+		return sim.skipArgs(opcode)
 	}
 
 	return nil
 }
 
+// skipArgs reads and discards the arguments of a command the simulator
+// ignores, so that they aren't taken for commands of their own. An opcode
+// oi doesn't know has no arguments it can skip.
+func (sim *RoombaSimulator) skipArgs(opcode byte) error {
+	_, _, err := oi.ReadArgs(cmdReader{sim}, opcode)
+	return err
+}
+
+// changeMode handles a mode command from the client.
+func (sim *RoombaSimulator) changeMode(m sensors.Mode) {
+	sim.lock()
+	defer sim.mu.Unlock()
+	sim.seeking = false
+	sim.cleaning = NotCleaning
+	sim.asleep = false
+	sim.setMode(m)
+}
+
+// sensorValue returns the current value of a sensor packet, or of all the
+// packets in a group packet, and false if there is no such packet. Packets
+// the simulator has no value for read as zeros.
+func (sim *RoombaSimulator) sensorValue(packetId byte) ([]byte, bool) {
+	sim.lock()
+	defer sim.mu.Unlock()
+
+	if members, ok := sensors.Members(packetId); ok {
+		var value []byte
+		for _, id := range members {
+			value = append(value, sim.singleSensorValue(id)...)
+		}
+		return value, true
+	}
+	if _, err := sensors.Length(packetId); err != nil {
+		log.Printf("unknown sensor packet id %d", packetId)
+		return nil, false
+	}
+	return sim.singleSensorValue(packetId), true
+}
+
+// singleSensorValue returns the current value of a single sensor packet.
+func (sim *RoombaSimulator) singleSensorValue(packetId byte) []byte {
+	if value, ok := sim.overriddenSensorValue(packetId); ok {
+		return value
+	}
+	if value, ok := sim.physicsSensorValue(packetId); ok {
+		return value
+	}
+	if value, ok := sim.worldSensorValue(packetId); ok {
+		return value
+	}
+	if value, ok := sim.batterySensorValue(packetId); ok {
+		return value
+	}
+	if value, ok := sim.irSensorValue(packetId); ok {
+		return value
+	}
+	if value, ok := sim.songSensorValue(packetId); ok {
+		return value
+	}
+	if value, ok := sim.actuatorSensorValue(packetId); ok {
+		return value
+	}
+	switch packetId {
+	case sensors.PacketStreamPackets:
+		return []byte{byte(len(sim.streamPackets))}
+	case sensors.PacketMode:
+		return []byte{byte(sim.mode)}
+	case sensors.PacketRequestedRadius:
+		return sim.RequestedRadius
+	case sensors.PacketRequestedVelocity:
+		return sim.RequestedVelocity
+	}
+	if value, ok := sim.sensorDefaults[packetId]; ok {
+		return value
+	}
+	if !sim.unmocked[packetId] {
+		log.Printf("no mock value for sensor packet id %d, sending zeros", packetId)
+		sim.unmocked[packetId] = true
+	}
+	n, _ := sensors.Length(packetId)
+	return make([]byte, n)
+}
+
+// cmdReader reads the bytes of the command being executed, through
+// sim.read.
+type cmdReader struct {
+	sim *RoombaSimulator
+}
+
+func (r cmdReader) Read(p []byte) (int, error) {
+	buf, err := r.sim.read(len(p))
+	return copy(p, buf), err
+}
+
 // Reads given number of bytes from the Reader sim.rw.
-func (sim *RoombaSimulator) read(n int) []byte {
+func (sim *RoombaSimulator) read(n int) ([]byte, error) {
 	buf := make([]byte, n)
-	nRead, err := sim.rw.Read(buf)
-	if n != nRead {
-		if err != nil {
-			log.Printf("error reading in RoombaSimulator: %v", err)
-		}
-		//log.Printf("read %d bytes when expected %d", nRead, n)
-		return []byte{}
+	if n == 0 {
+		return buf, nil
+	}
+	// A command's bytes needn't arrive in one piece, especially over a
+	// lossy link.
+	if _, err := io.ReadFull(sim.rw, buf); err != nil {
+		log.Printf("error reading in RoombaSimulator: %v", err)
+		return nil, err
 	}
 	log.Printf("roomba reads: %v", buf)
 	sim.ReadBytes.Write(buf)
-	return buf
+	sim.cmdBytes = append(sim.cmdBytes, buf...)
+	return buf, nil
+}
+
+func (sim *RoombaSimulator) readByte() (byte, error) {
+	buf, err := sim.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
 }
 
 // Writes bytes to the Writer w asynchronously.
 func (sim *RoombaSimulator) write(b []byte) {
+	if len(b) == 0 {
+		return
+	}
 	log.Printf("roomba says: %v", b)
-	sim.writeQ <- b
+	select {
+	case sim.writeQ <- b:
+	case <-sim.quit:
+	}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
 }
 
 // Helper for merging reader and writer into a ReadWriter.
@@ -199,32 +547,70 @@ type readWriter struct {
 	io.Writer
 }
 
-func MakeRoombaSim() (*RoombaSimulator, *readWriter) {
-	// Input: driver writes, simulator reads.
-	inp_r, inp_w := io.Pipe()
-
-	// Ouput: simulator writes, driver reads.
-	out_r, out_w := io.Pipe()
+// ServeRoombaSim starts a simulator that speaks the OI over the given
+// connection, such as a network connection or serial port. Stopping the
+// simulator closes the connection, which is what interrupts its reads, so
+// the simulator owns it from now on.
+func ServeRoombaSim(rwc io.ReadWriteCloser) *RoombaSimulator {
+	return serveRoombaSim(rwc, clock.Real{}, rwc)
+}
 
-	readBytes := &bytes.Buffer{}
-	writtenBytes := &bytes.Buffer{}
+// serveRoombaSim starts a simulator on a connection, which the closers shut
+// down when the simulator stops.
+func serveRoombaSim(rw io.ReadWriter, c clock.Clock, closers ...io.Closer) *RoombaSimulator {
+	sim := newRoombaSim(rw, c)
+	sim.closers = closers
+	sim.wg.Add(2)
+	go sim.serve()
+	go sim.stream()
+	return sim
+}
 
+func newRoombaSim(rw io.ReadWriter, c clock.Clock) *RoombaSimulator {
 	sim := &RoombaSimulator{
-		rw: &readWriter{
-			// Log all read bytes to ReadBytes.
-			io.TeeReader(inp_r, readBytes),
-			// Log all written bytes to writtenBytes.
-			io.MultiWriter(out_w, writtenBytes),
-		},
-		writeQ:    make(chan []byte, 15),
-		ReadBytes: *readBytes,
+		clock:    c,
+		writeQ:   make(chan []byte, 15),
+		quit:     make(chan struct{}),
+		unmocked: make(map[byte]bool),
+
+		sensorDefaults: make(map[byte][]byte),
+		overrides:      make(map[byte]*sensorOverride),
+
+		battery: battery{charge: 0.9 * BatteryCapacity, capacity: BatteryCapacity},
+		beacon:  irRobotBeacon,
 
 		RequestedRadius:   []byte{0, 0},
 		RequestedVelocity: []byte{0, 0},
 	}
-	go sim.serve()
+	for id, value := range MockSensorValues {
+		sim.sensorDefaults[id] = append([]byte(nil), value...)
+	}
+	sim.cmdDone = sync.NewCond(&sim.mu)
+	// All traffic goes through the link, so that faults can be injected.
+	sim.link = newLink(rw, c, sim.quit)
+	sim.rw = sim.link
+	return sim
+}
+
+func MakeRoombaSim() (*RoombaSimulator, *readWriter) {
+	return MakeRoombaSimWithClock(clock.Real{})
+}
+
+// MakeRoombaSimWithClock is like MakeRoombaSim, but the simulated robot runs
+// on the given clock. With a clock.Manual, tests control the passing of time.
+func MakeRoombaSimWithClock(c clock.Clock) (*RoombaSimulator, *readWriter) {
+	// Input: driver writes, simulator reads.
+	inp_r, inp_w := io.Pipe()
+
+	// Ouput: simulator writes, driver reads.
+	out_r, out_w := io.Pipe()
+
+	// Once the simulator stops, the client's reads and writes fail as they
+	// would on a closed connection.
+	sim := serveRoombaSim(&readWriter{inp_r, out_w}, c, inp_r, out_w)
 
-	rw := &readWriter{out_r, inp_w}
+	sim.sent = &countingWriter{w: inp_w}
+	rw := &readWriter{out_r, sim.sent}
 
 	return sim, rw
 }
@@ -0,0 +1,139 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/cquinn/doombot/clock"
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+// testStart is when the virtual clocks of test simulators start.
+var testStart = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestSim returns a simulator on a virtual clock, its client's end of
+// the connection and the clock. It is closed when the test finishes.
+func newTestSim(t *testing.T) (*RoombaSimulator, *readWriter, *clock.Manual) {
+	c := clock.NewManual(testStart)
+	s, rw := MakeRoombaSimWithClock(c)
+	t.Cleanup(func() { s.Close() })
+	return s, rw, c
+}
+
+// send writes the commands to the simulator and waits for it to execute
+// them.
+func send(t *testing.T, s *RoombaSimulator, rw *readWriter, cmds ...oi.Command) {
+	t.Helper()
+	if err := oi.NewEncoder(rw).Send(cmds...); err != nil {
+		t.Fatal(err)
+	}
+	s.WaitForCommands()
+}
+
+// sendBytes writes raw bytes to the simulator, for commands the encoder
+// would refuse, and waits for it to execute them.
+func sendBytes(t *testing.T, s *RoombaSimulator, rw *readWriter, b ...byte) {
+	t.Helper()
+	if _, err := rw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	s.WaitForCommands()
+}
+
+// sensor asks the simulator for a sensor packet and returns its value.
+func sensor(t *testing.T, rw *readWriter, id byte) []byte {
+	t.Helper()
+	if err := oi.NewEncoder(rw).Send(oi.Sensors{ID: id}); err != nil {
+		t.Fatal(err)
+	}
+	n, _ := sensors.Length(id)
+	value := make([]byte, n)
+	if _, err := io.ReadFull(rw, value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// sensor16 asks the simulator for a two byte sensor packet and returns its
+// value.
+func sensor16(t *testing.T, rw *readWriter, id byte) int16 {
+	t.Helper()
+	return int16(binary.BigEndian.Uint16(sensor(t, rw, id)))
+}
+
+func TestCommandsIgnoredWhileOff(t *testing.T) {
+	// Each command has a Start (128) or Reset (7) among its arguments,
+	// which the simulator would obey if it didn't skip them.
+	tests := []struct {
+		name string
+		cmd  []byte
+	}{
+		{"Baud", []byte{oi.OpBaud, 7}},
+		{"Drive", []byte{oi.OpDrive, 0, 128, 0, 7}},
+		{"Sensors", []byte{oi.OpSensors, 7}},
+		{"PauseStream", []byte{oi.OpPauseStream, 128}},
+		{"Song", []byte{oi.OpSong, 0, 2, 128, 16, 7, 16}},
+		{"Script", []byte{oi.OpScript, 2, 128, 7}},
+		{"QueryList", []byte{oi.OpQueryList, 2, 7, 128}},
+		{"Stream", []byte{oi.OpStream, 1, 128}},
+	}
+	for _, test := range tests {
+		s, rw, _ := newTestSim(t)
+		sendBytes(t, s, rw, test.cmd...)
+		if m := s.Mode(); m != sensors.Off {
+			t.Errorf("%s: mode %v after a command sent while off, want Off", test.name, m)
+		}
+		if cmds := s.TakeCommands(); len(cmds) != 0 {
+			t.Errorf("%s: executed %v while off", test.name, cmds)
+		}
+		if s.Baud() != DefaultBaud {
+			t.Errorf("%s: baud %d, want %d", test.name, s.Baud(), DefaultBaud)
+		}
+
+		send(t, s, rw, oi.Start{})
+		if m := s.Mode(); m != sensors.Passive {
+			t.Errorf("%s: mode %v after Start, want Passive", test.name, m)
+		}
+		if cmds := s.TakeCommands(); len(cmds) != 1 || cmds[0].Command != (oi.Start{}) {
+			t.Errorf("%s: executed %v, want Start", test.name, cmds)
+		}
+	}
+}
+
+func TestGroupPackets(t *testing.T) {
+	s, rw, _ := newTestSim(t)
+	send(t, s, rw, oi.Start{})
+	if err := s.SetSensor(sensors.PacketBumps, []byte{0x03}); err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range []byte{0, 1, 2, 3, 4, 5, 6, 100, 101, 106, 107} {
+		members, _ := sensors.Members(group)
+		value := sensor(t, rw, group)
+
+		// A group reads the same as a query for its members, overrides
+		// included. The simulator answers each member as it reads it, so
+		// the query is written while the answer is read.
+		written := make(chan error, 1)
+		go func() {
+			_, err := rw.Write(append([]byte{oi.OpQueryList, byte(len(members))}, members...))
+			written <- err
+		}()
+		want := make([]byte, len(value))
+		if _, err := io.ReadFull(rw, want); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-written; err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, want) {
+			t.Errorf("group %d is % d, want % d", group, value, want)
+		}
+		if members[0] == sensors.PacketBumps && value[0] != 0x03 {
+			t.Errorf("group %d has bumps %#02x, want the override", group, value[0])
+		}
+	}
+}
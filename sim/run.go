@@ -0,0 +1,102 @@
+package sim
+
+import (
+	"time"
+)
+
+const (
+	// The robot's path is sampled at this interval of simulated time,
+	// which is fine enough to draw and keeps a long run small.
+	sampleInterval = 100 * time.Millisecond
+	// A run remembers at most this many samples and bumps; older ones are
+	// dropped.
+	maxRunSamples = 100000
+)
+
+// Sample is the robot's pose at a moment in a run.
+type Sample struct {
+	Time time.Time
+	Pose Pose
+	// Placed is set when the robot was put here by SetPose rather than
+	// driving here.
+	Placed bool
+}
+
+// BumpEvent is the bumper being pressed during a run.
+type BumpEvent struct {
+	Time        time.Time
+	Pose        Pose
+	Left, Right bool
+}
+
+// Run is the history of the simulated robot in its world, for drawing with
+// WritePNG or WriteGIF.
+type Run struct {
+	World *World
+	Path  []Sample
+	Bumps []BumpEvent
+	// Where the other robots in the world are at the end of the run.
+	Robots []Pose
+}
+
+// recording is the run so far, kept as the physics advances.
+type recording struct {
+	path        []Sample
+	bumps       []BumpEvent
+	left, right bool // the bumper at the last step
+}
+
+// Run returns the history of the robot since it was put in its world.
+func (sim *RoombaSimulator) Run() Run {
+	sim.lock()
+	defer sim.mu.Unlock()
+	path := append([]Sample(nil), sim.recording.path...)
+	// End the path where the robot is now, between samples.
+	if n := len(path); n > 0 && path[n-1].Pose != sim.pose {
+		path = append(path, Sample{Time: sim.lastUpdate, Pose: sim.pose})
+	}
+	run := Run{
+		World: sim.world,
+		Path:  path,
+		Bumps: append([]BumpEvent(nil), sim.recording.bumps...),
+	}
+	if sim.world != nil {
+		for _, b := range sim.world.others(sim) {
+			run.Robots = append(run.Robots, b.pose)
+		}
+	}
+	return run
+}
+
+// record notes the robot's pose and bumper at time t, after a physics step.
+func (sim *RoombaSimulator) record(t time.Time) {
+	r := &sim.recording
+	if n := len(r.path); n == 0 ||
+		(t.Sub(r.path[n-1].Time) >= sampleInterval && r.path[n-1].Pose != sim.pose) {
+		r.path = appendSample(r.path, Sample{Time: t, Pose: sim.pose})
+	}
+
+	if !sim.moving() {
+		return
+	}
+	left, right := sim.bumps()
+	if left && !r.left || right && !r.right {
+		r.bumps = append(r.bumps, BumpEvent{t, sim.pose, left, right})
+		if len(r.bumps) > maxRunSamples {
+			r.bumps = r.bumps[1:]
+		}
+	}
+	r.left, r.right = left, right
+}
+
+// place records the robot being put down at a new pose.
+func (sim *RoombaSimulator) place(t time.Time) {
+	sim.recording.path = appendSample(sim.recording.path, Sample{t, sim.pose, true})
+}
+
+func appendSample(path []Sample, s Sample) []Sample {
+	if len(path) == maxRunSamples {
+		path = append(path[:0], path[1:]...)
+	}
+	return append(path, s)
+}
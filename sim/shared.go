@@ -0,0 +1,181 @@
+package sim
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// irRobotBeacon is the IR character a simulated robot sends to the
+	// other robots in its world, until it is changed with SetBeacon. This
+	// is a simulator-only feature: the Create 2 has no IR emitter, and only
+	// docks and virtual walls send IR characters. It lets robots in one
+	// world find each other, and no iRobot device uses this character.
+	irRobotBeacon byte = 200
+	// beaconRange is how far a robot's IR beacon reaches, in mm.
+	beaconRange = 2000.0
+)
+
+// robotBody is a robot as the other robots in its world see it.
+type robotBody struct {
+	sim    *RoombaSimulator
+	pose   Pose
+	beacon byte
+}
+
+// join adds a robot to the world, and returns where it starts: the first
+// of the world's start poses that isn't taken by another robot.
+func (w *World) join(sim *RoombaSimulator, beacon byte) Pose {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	p := w.Start
+	for _, start := range append([]Pose{w.Start}, w.Starts...) {
+		if w.clearOfRobots(start.point()) {
+			p = start
+			break
+		}
+	}
+	w.robots = append(w.robots, &robotBody{sim, p, beacon})
+	return p
+}
+
+// clearOfRobots reports whether a robot could be put at a point without
+// overlapping the robots already in the world.
+func (w *World) clearOfRobots(p Point) bool {
+	for _, b := range w.robots {
+		if p.dist(b.pose.point()) < 2*RobotRadius {
+			return false
+		}
+	}
+	return true
+}
+
+// leave removes a robot from the world.
+func (w *World) leave(sim *RoombaSimulator) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, b := range w.robots {
+		if b.sim == sim {
+			w.robots = append(w.robots[:i], w.robots[i+1:]...)
+			return
+		}
+	}
+}
+
+// update records a robot's new pose and beacon.
+func (w *World) update(sim *RoombaSimulator, p Pose, beacon byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, b := range w.robots {
+		if b.sim == sim {
+			b.pose, b.beacon = p, beacon
+			return
+		}
+	}
+}
+
+// others returns the robots in the world other than sim.
+func (w *World) others(sim *RoombaSimulator) []robotBody {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var bodies []robotBody
+	for _, b := range w.robots {
+		if b.sim != sim {
+			bodies = append(bodies, *b)
+		}
+	}
+	return bodies
+}
+
+// catchUp advances all the robots in a shared world to now together, a
+// physics step at a time, so that each sees the others where they are at
+// that moment. A robot alone in its world is left to advance itself.
+func (w *World) catchUp(now time.Time) {
+	w.stepMu.Lock()
+	defer w.stepMu.Unlock()
+	w.mu.Lock()
+	robots := make([]*RoombaSimulator, len(w.robots))
+	for i, b := range w.robots {
+		robots[i] = b.sim
+	}
+	w.mu.Unlock()
+	if len(robots) < 2 {
+		w.time = now
+		return
+	}
+	if w.time.IsZero() || w.time.After(now) {
+		w.time = now
+	}
+	for w.time.Before(now) {
+		w.time = w.time.Add(physicsStep)
+		if w.time.After(now) {
+			w.time = now
+		}
+		for _, sim := range robots {
+			sim.mu.Lock()
+			sim.advance(w.time)
+			sim.mu.Unlock()
+		}
+	}
+}
+
+// lock locks the robot's state and brings it up to date. In a world shared
+// with other robots, all of them are brought up to date first.
+func (sim *RoombaSimulator) lock() {
+	sim.mu.Lock()
+	w := sim.world
+	sim.mu.Unlock()
+	if w != nil {
+		w.catchUp(sim.now())
+	}
+	sim.mu.Lock()
+	sim.advance(sim.now())
+}
+
+// publish tells the other robots in the world where this one is.
+func (sim *RoombaSimulator) publish() {
+	if sim.world != nil {
+		sim.world.update(sim, sim.pose, sim.beacon)
+	}
+}
+
+// SetBeacon sets the IR character the robot sends to the other robots in
+// its world, which they see on their IR receivers. Zero turns the beacon
+// off. A real Create 2 can't send IR, so this only exists in the simulator.
+func (sim *RoombaSimulator) SetBeacon(c byte) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.beacon = c
+	sim.publish()
+}
+
+// robotClearance returns the distance from a point to the nearest of the
+// other robots' footprints, and the closest point on it.
+func (sim *RoombaSimulator) robotClearance(p Point) (float64, Point) {
+	best := math.Inf(1)
+	var closest Point
+	for _, b := range sim.world.others(sim) {
+		c := b.pose.point()
+		d := p.dist(c)
+		if d-RobotRadius < best {
+			best = d - RobotRadius
+			closest = c
+			if d > 0 {
+				closest = Point{c.X + (p.X-c.X)*RobotRadius/d, c.Y + (p.Y-c.Y)*RobotRadius/d}
+			}
+		}
+	}
+	return best, closest
+}
+
+// beacons returns the robots in the world whose beacons reach the point.
+func (sim *RoombaSimulator) beacons(p Point) []robotBody {
+	var seen []robotBody
+	for _, b := range sim.world.others(sim) {
+		if b.beacon != 0 && p.dist(b.pose.point()) <= beaconRange &&
+			sim.world.lineOfSight(p, b.pose.point()) {
+			seen = append(seen, b)
+		}
+	}
+	return seen
+}
@@ -0,0 +1,137 @@
+package sim
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+const (
+	// The range of MIDI note numbers the OI plays.
+	minNote = 31
+	maxNote = 127
+)
+
+// Note is one note of a song: a MIDI note number, and a duration in 64ths
+// of a second. Note numbers outside 31-127 are rests.
+type Note struct {
+	Number   byte
+	Duration byte
+}
+
+// Song is a sequence of up to 16 notes.
+type Song []Note
+
+// Duration returns how long the song takes to play.
+func (s Song) Duration() time.Duration {
+	var ticks int
+	for _, n := range s {
+		ticks += int(n.Duration)
+	}
+	return time.Duration(ticks) * time.Second / 64
+}
+
+// Song returns the song stored in the given slot, and false if there is
+// none.
+func (sim *RoombaSimulator) Song(num int) (Song, bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if num < 0 || num >= oi.SongSlots || sim.songs[num] == nil {
+		return nil, false
+	}
+	return append(Song(nil), sim.songs[num]...), true
+}
+
+// SongPlaying returns the number of the song most recently played, and
+// whether it is still playing.
+func (sim *RoombaSimulator) SongPlaying() (int, bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.songNumber, sim.now().Before(sim.songEnd)
+}
+
+// ProtocolErrors returns the problems the simulator has found with the
+// commands it has been sent, such as malformed songs.
+func (sim *RoombaSimulator) ProtocolErrors() []error {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return append([]error(nil), sim.errors...)
+}
+
+// protocolError logs and records a problem with a command.
+func (sim *RoombaSimulator) protocolError(format string, args ...interface{}) {
+	err := fmt.Errorf(format, args...)
+	log.Printf("protocol error: %v", err)
+	sim.mu.Lock()
+	sim.errors = append(sim.errors, err)
+	sim.mu.Unlock()
+}
+
+// defineSong handles the Song command, which has already been read. Songs
+// with a bad number or length are reported, and not stored. Notes out of
+// range or of zero duration are reported too, but the song is still stored
+// and played, because the robot plays them as rests.
+func (sim *RoombaSimulator) defineSong(num byte, song Song) {
+	if num >= oi.SongSlots {
+		sim.protocolError("song number %d out of range 0-%d", num, oi.SongSlots-1)
+		return
+	}
+	if len(song) < 1 || len(song) > oi.MaxNotes {
+		sim.protocolError("song %d has %d notes, must have 1-%d", num, len(song), oi.MaxNotes)
+		return
+	}
+	for i, n := range song {
+		// These are legal, so they don't stop the song being stored,
+		// but they are far more likely to be a bug than a deliberate
+		// rest.
+		if n.Number < minNote || n.Number > maxNote {
+			sim.protocolError("song %d note %d: note number %d out of range %d-%d",
+				num, i, n.Number, minNote, maxNote)
+		}
+		if n.Duration == 0 {
+			sim.protocolError("song %d note %d: zero duration", num, i)
+		}
+	}
+	log.Printf("defined song %d: %v", num, song)
+	sim.mu.Lock()
+	sim.songs[num] = song
+	sim.mu.Unlock()
+}
+
+// playSong handles the Play command.
+func (sim *RoombaSimulator) playSong(num byte) {
+	if num >= oi.SongSlots {
+		sim.protocolError("song number %d out of range 0-%d", num, oi.SongSlots-1)
+		return
+	}
+	sim.mu.Lock()
+	song := sim.songs[num]
+	sim.mu.Unlock()
+	if song == nil {
+		sim.protocolError("song %d played without being defined", num)
+		return
+	}
+	sim.actuate("Play", func() {
+		log.Printf("playing song %d for %v", num, song.Duration())
+		sim.songNumber = int(num)
+		sim.songEnd = sim.now().Add(song.Duration())
+	})
+}
+
+// songSensorValue returns the value of the song sensor packets, and false
+// for any other packet.
+func (sim *RoombaSimulator) songSensorValue(packetId byte) ([]byte, bool) {
+	switch packetId {
+	case sensors.PacketSongNumber:
+		return []byte{byte(sim.songNumber)}, true
+	case sensors.PacketSongPlaying:
+		if sim.now().Before(sim.songEnd) {
+			return []byte{1}, true
+		}
+		return []byte{0}, true
+	}
+	return nil, false
+}
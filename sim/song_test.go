@@ -0,0 +1,89 @@
+package sim
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+func TestSongs(t *testing.T) {
+	// Song 1 is defined before each test, half a second of middle C.
+	tests := []struct {
+		name    string
+		cmds    []byte
+		errs    []string // in the protocol errors, one each
+		number  byte     // the song number packet afterwards
+		playing byte     // the song playing packet afterwards
+	}{
+		{"play", []byte{oi.OpPlay, 1},
+			nil, 1, 1},
+		{"song number 5", []byte{oi.OpSong, 5, 1, 60, 16, oi.OpPlay, 5},
+			[]string{"song number 5 out of range", "song number 5 out of range"}, 0, 0},
+		{"17 notes", append(append([]byte{oi.OpSong, 1, 17}, repeatNote(60, 16, 17)...), oi.OpPlay, 1),
+			[]string{"song 1 has 17 notes"}, 1, 1},
+		{"no notes", []byte{oi.OpSong, 2, 0, oi.OpPlay, 2},
+			[]string{"song 2 has 0 notes", "song 2 played without being defined"}, 0, 0},
+		{"note too low", []byte{oi.OpSong, 2, 2, 30, 16, 60, 16, oi.OpPlay, 2},
+			[]string{"song 2 note 0: note number 30 out of range"}, 2, 1},
+		{"note too high", []byte{oi.OpSong, 2, 2, 60, 16, 128, 16, oi.OpPlay, 2},
+			[]string{"song 2 note 1: note number 128 out of range"}, 2, 1},
+		{"zero duration", []byte{oi.OpSong, 2, 2, 60, 0, 60, 16, oi.OpPlay, 2},
+			[]string{"song 2 note 0: zero duration"}, 2, 1},
+		{"play undefined", []byte{oi.OpPlay, 3},
+			[]string{"song 3 played without being defined"}, 0, 0},
+	}
+	for _, test := range tests {
+		s, rw, _ := newTestSim(t)
+		send(t, s, rw, oi.Start{}, oi.Safe{}, oi.Song{Num: 1, Notes: []oi.Note{{Number: 60, Duration: 32}}})
+		sendBytes(t, s, rw, test.cmds...)
+
+		errs := s.ProtocolErrors()
+		if len(errs) != len(test.errs) {
+			t.Errorf("%s: protocol errors %v, want %d", test.name, errs, len(test.errs))
+		} else {
+			for i, want := range test.errs {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("%s: protocol error %q, want %q", test.name, errs[i], want)
+				}
+			}
+		}
+		if got := sensor(t, rw, sensors.PacketSongNumber)[0]; got != test.number {
+			t.Errorf("%s: song number %d, want %d", test.name, got, test.number)
+		}
+		if got := sensor(t, rw, sensors.PacketSongPlaying)[0]; got != test.playing {
+			t.Errorf("%s: song playing %d, want %d", test.name, got, test.playing)
+		}
+	}
+}
+
+func repeatNote(number, duration byte, n int) []byte {
+	var b []byte
+	for i := 0; i < n; i++ {
+		b = append(b, number, duration)
+	}
+	return b
+}
+
+func TestTruncatedSong(t *testing.T) {
+	s, rw, _ := newTestSim(t)
+	send(t, s, rw, oi.Start{}, oi.Safe{})
+	// Three notes are promised, but the connection closes after one.
+	if _, err := rw.Write([]byte{oi.OpSong, 0, 3, 60, 16}); err != nil {
+		t.Fatal(err)
+	}
+	s.sent.w.(io.Closer).Close()
+	<-s.Done()
+
+	if err := s.Err(); err == nil || !strings.Contains(err.Error(), "reading arguments of opcode 140") {
+		t.Errorf("simulator stopped with %v, want an error reading the song", err)
+	}
+	if song, ok := s.Song(0); ok {
+		t.Errorf("truncated song stored as %v", song)
+	}
+	if num, playing := s.SongPlaying(); num != 0 || playing {
+		t.Errorf("song %d playing %t, want 0 not playing", num, playing)
+	}
+}
@@ -0,0 +1,91 @@
+package sim
+
+import (
+	"bytes"
+	"log"
+	"time"
+)
+
+// A real robot sends a stream frame every 15ms, the rate at which it
+// updates its sensors.
+const streamPeriod = 15 * time.Millisecond
+
+// startStream handles the Stream command, replacing the list of streamed
+// packets. An empty list stops the stream.
+func (sim *RoombaSimulator) startStream(packetIds []byte) {
+	log.Printf("streaming packets %v", packetIds)
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.streamPackets = packetIds
+	sim.streamPaused = false
+}
+
+// pauseStream handles the Pause/Resume Stream command, which keeps the list
+// of streamed packets either way.
+func (sim *RoombaSimulator) pauseStream(pause bool) {
+	if pause {
+		log.Printf("stream paused")
+	} else {
+		log.Printf("stream resumed")
+	}
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.streamPaused = pause
+}
+
+// stream sends a frame of the streamed packets every streamPeriod, while
+// there is a stream that isn't paused.
+func (sim *RoombaSimulator) stream() {
+	defer sim.wg.Done()
+	ticker := sim.clock.NewTicker(streamPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sim.quit:
+			return
+		case <-ticker.C():
+		}
+
+		sim.mu.Lock()
+		packetIds := sim.streamPackets
+		paused := sim.streamPaused
+		sim.mu.Unlock()
+		if paused || len(packetIds) == 0 {
+			continue
+		}
+
+		// Frames are too frequent to log each one, so skip sim.write.
+		select {
+		case <-sim.quit:
+			return
+		case sim.writeQ <- sim.streamFrame(packetIds):
+		}
+	}
+}
+
+// streamFrame returns a stream frame for the given packets:
+// [19][N-bytes][Packet ID 1][Packet 1 data...][Packet ID 2]...[Checksum]
+func (sim *RoombaSimulator) streamFrame(packetIds []byte) []byte {
+	// Contains just packet ids and values, no headers.
+	sensorValues := bytes.Buffer{}
+	for _, packetId := range packetIds {
+		value, _ := sim.sensorValue(packetId)
+		sensorValues.WriteByte(packetId)
+		sensorValues.Write(value)
+	}
+
+	output := bytes.Buffer{}
+	// Header.
+	output.WriteByte(19)
+	// Data length.
+	output.WriteByte(byte(sensorValues.Len()))
+	output.Write(sensorValues.Bytes())
+	// The checksum makes the low byte of the sum of the whole frame,
+	// header included, zero.
+	checksum := byte(0)
+	for _, b := range output.Bytes() {
+		checksum -= b
+	}
+	output.WriteByte(sim.link.corruptChecksum(checksum))
+	return output.Bytes()
+}
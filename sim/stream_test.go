@@ -0,0 +1,77 @@
+package sim
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+func TestStream(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	ids := []byte{sensors.PacketBumps, sensors.PacketMode, sensors.PacketDistance}
+	send(t, s, rw, oi.Start{}, oi.Safe{}, oi.DirectDrive{Right: 200, Left: 200}, oi.Stream{IDs: ids})
+	frames := sensors.NewStreamReader(rw)
+
+	// A frame every 15ms, with the distance travelled since the last.
+	for i := 0; i < 3; i++ {
+		c.Advance(streamPeriod)
+		f, err := frames.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(f.IDs, ids) || f.Mode != sensors.Safe || f.Distance != 3 {
+			t.Errorf("frame %d is %+v, want Safe mode and 3mm", i, f)
+		}
+	}
+	if stats := frames.Stats(); stats != (sensors.StreamStats{Frames: 3}) {
+		t.Errorf("stream stats %+v, want 3 good frames", stats)
+	}
+}
+
+func TestStreamPauseAndStop(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  oi.Command
+	}{
+		{"pause", oi.PauseStream{Resume: false}},
+		{"empty stream", oi.Stream{}},
+		{"stop", oi.Stop{}},
+	}
+	for _, test := range tests {
+		s, rw, c := newTestSim(t)
+		send(t, s, rw, oi.Start{}, oi.Stream{IDs: []byte{sensors.PacketMode}})
+		frames := sensors.NewStreamReader(rw)
+		c.Advance(streamPeriod)
+		if _, err := frames.Next(); err != nil {
+			t.Fatal(err)
+		}
+
+		send(t, s, rw, test.cmd)
+		for i := 0; i < 10; i++ {
+			c.Advance(streamPeriod)
+		}
+		// Anything streamed meanwhile would arrive before the answer.
+		send(t, s, rw, oi.Start{})
+		if got := sensor(t, rw, sensors.PacketStreamPackets)[0]; got == sensors.StreamHeader {
+			t.Errorf("%s: stream frame sent after %v", test.name, test.cmd)
+		}
+	}
+}
+
+func TestStreamResume(t *testing.T) {
+	s, rw, c := newTestSim(t)
+	send(t, s, rw, oi.Start{}, oi.Stream{IDs: []byte{sensors.PacketMode}}, oi.PauseStream{Resume: false})
+	c.Advance(10 * streamPeriod)
+	send(t, s, rw, oi.PauseStream{Resume: true})
+	frames := sensors.NewStreamReader(rw)
+	c.Advance(streamPeriod)
+	f, err := frames.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Mode != sensors.Passive {
+		t.Errorf("resumed frame is %+v, want Passive mode", f)
+	}
+}
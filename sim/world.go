@@ -0,0 +1,416 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cquinn/doombot/sensors"
+	"github.com/xa4a/go-roomba"
+)
+
+const (
+	// RobotRadius is the radius of the Create 2's round footprint, in mm.
+	RobotRadius = 174.0
+
+	// A bump registers when geometry is within this distance of the
+	// bumper, so that a robot stopped against a wall keeps reporting it.
+	bumpTolerance = 1.0
+	// Contacts within this angle either side of straight ahead press both
+	// halves of the bumper.
+	bumpCenterAngle = 15 * math.Pi / 180
+	// Contacts further round than this are on the robot's flank and miss
+	// the bumper entirely.
+	bumpMaxAngle = 80 * math.Pi / 180
+
+	// The wall sensor sits on the right of the bumper and looks sideways.
+	wallSensorBearing = -60 * math.Pi / 180
+	wallSensorRange   = 120.0 // mm, beyond which the signal is zero
+	wallSeenRange     = 60.0  // mm, within which packet 8 reports a wall
+)
+
+// Point is a location in the world, in mm.
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Polygon is a closed shape given by its vertices in order.
+type Polygon []Point
+
+// World describes the arena the simulated robot drives around in: a
+// rectangle from (0, 0) to (Width, Height) containing polygon obstacles,
+// which the robot bumps into, and drop-offs such as stairs or table edges,
+// which it can drive off. It may also have a charging dock. All distances
+// are in mm.
+//
+// Several simulators can share a world, each put in it with SetWorld. They
+// bump into each other, and see each other's IR beacons. Each robot starts
+// at the first of Start and then Starts that is clear of the others. All
+// the robots in a world must run on the same clock.
+type World struct {
+	Width     float64   `json:"width"`
+	Height    float64   `json:"height"`
+	Start     Pose      `json:"start"`
+	Starts    []Pose    `json:"starts"`
+	Obstacles []Polygon `json:"obstacles"`
+	Drops     []Polygon `json:"drops"`
+	Dock      *Dock     `json:"dock"`
+
+	segments []segment
+
+	// mu guards the robots in the world. stepMu is held while catchUp
+	// moves them, and is taken before any robot's mu; a robot's mu is
+	// taken before the world's mu.
+	mu     sync.Mutex
+	robots []*robotBody
+	stepMu sync.Mutex
+	time   time.Time // when catchUp last moved the robots to
+}
+
+// LoadWorld reads a JSON world description from the named file. For example:
+//
+//	{
+//	  "width": 4000, "height": 3000,
+//	  "start": {"x": 500, "y": 500, "heading": 0},
+//	  "starts": [{"x": 3500, "y": 2500, "heading": 3.14}],
+//	  "obstacles": [
+//	    [{"x": 1500, "y": 1000}, {"x": 2000, "y": 1000}, {"x": 2000, "y": 1500}]
+//	  ],
+//	  "drops": [
+//	    [{"x": 3000, "y": 0}, {"x": 4000, "y": 0}, {"x": 4000, "y": 800}, {"x": 3000, "y": 800}]
+//	  ],
+//	  "dock": {"x": 0, "y": 1500, "heading": 0}
+//	}
+func LoadWorld(path string) (*World, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	w, err := ParseWorld(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return w, nil
+}
+
+// ParseWorld reads a JSON world description, as described by LoadWorld.
+func ParseWorld(r io.Reader) (*World, error) {
+	w := &World{}
+	if err := json.NewDecoder(r).Decode(w); err != nil {
+		return nil, err
+	}
+	if err := w.init(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewWorld returns an empty arena of the given size, with the robot
+// starting in the middle.
+func NewWorld(width, height float64) *World {
+	w := &World{
+		Width:  width,
+		Height: height,
+		Start:  Pose{X: width / 2, Y: height / 2},
+	}
+	if err := w.init(); err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// AddObstacle adds a polygon obstacle to the world.
+func (w *World) AddObstacle(p Polygon) error {
+	if len(p) < 3 {
+		return fmt.Errorf("obstacle needs at least 3 points, got %d", len(p))
+	}
+	w.Obstacles = append(w.Obstacles, p)
+	w.segments = append(w.segments, p.segments()...)
+	return nil
+}
+
+// AddDrop adds a drop-off region to the world.
+func (w *World) AddDrop(p Polygon) error {
+	if len(p) < 3 {
+		return fmt.Errorf("drop needs at least 3 points, got %d", len(p))
+	}
+	w.Drops = append(w.Drops, p)
+	return nil
+}
+
+// init validates the world and precomputes its geometry.
+func (w *World) init() error {
+	if w.Width <= 0 || w.Height <= 0 {
+		return fmt.Errorf("world size must be positive, got %vx%v", w.Width, w.Height)
+	}
+	obstacles := w.Obstacles
+	w.Obstacles = nil
+	w.segments = Polygon{
+		{0, 0}, {w.Width, 0}, {w.Width, w.Height}, {0, w.Height},
+	}.segments()
+	for i, p := range obstacles {
+		if err := w.AddObstacle(p); err != nil {
+			return fmt.Errorf("obstacle %d: %v", i, err)
+		}
+	}
+	for i, p := range w.Drops {
+		if len(p) < 3 {
+			return fmt.Errorf("drop %d: needs at least 3 points, got %d", i, len(p))
+		}
+	}
+	return nil
+}
+
+// clearance returns the distance from a point to the nearest geometry, and
+// the closest point on that geometry.
+func (w *World) clearance(p Point) (float64, Point) {
+	best := math.Inf(1)
+	var closest Point
+	for _, s := range w.segments {
+		c := s.closest(p)
+		if d := p.dist(c); d < best {
+			best, closest = d, c
+		}
+	}
+	return best, closest
+}
+
+// clearance returns the distance from a point to the nearest geometry or
+// other robot, and the closest point on it.
+func (sim *RoombaSimulator) clearance(p Point) (float64, Point) {
+	d, closest := sim.world.clearance(p)
+	if rd, rc := sim.robotClearance(p); rd < d {
+		return rd, rc
+	}
+	return d, closest
+}
+
+// raycast returns the distance along a ray to the nearest geometry, or
+// +Inf if the ray hits nothing.
+func (w *World) raycast(origin Point, angle float64) float64 {
+	dir := Point{math.Cos(angle), math.Sin(angle)}
+	best := math.Inf(1)
+	for _, s := range w.segments {
+		if d, ok := s.intersectRay(origin, dir); ok && d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// SetWorld puts the simulated robot into a world, at the world's start
+// pose, and starts a new Run. A nil world is an endless empty floor. A
+// robot leaves its old world, which matters when the world is shared.
+func (sim *RoombaSimulator) SetWorld(w *World) {
+	sim.lock()
+	defer sim.mu.Unlock()
+	if sim.world != nil {
+		sim.world.leave(sim)
+	}
+	// A Seek Dock in progress was steering for the old world's dock, so it
+	// stops along with the wheels it was driving.
+	if sim.seeking {
+		sim.rightVelocity, sim.leftVelocity = 0, 0
+	}
+	sim.seeking, sim.homing, sim.finalApproach = false, false, false
+	sim.world = w
+	if w != nil {
+		sim.pose = w.join(sim, sim.beacon)
+	}
+	sim.recording = recording{}
+	sim.place(sim.lastUpdate)
+}
+
+// moveTo moves the robot towards a new pose, stopping short at the point of
+// contact if the move would take it into the world's geometry.
+func (sim *RoombaSimulator) moveTo(p Pose) {
+	if sim.world == nil {
+		sim.pose = p
+		return
+	}
+	if sim.fallen() {
+		return
+	}
+	from, _ := sim.clearance(sim.pose.point())
+	to, _ := sim.clearance(p.point())
+	// Moves away from geometry are always allowed, so a robot placed
+	// overlapping something can still back out.
+	if to >= RobotRadius || to >= from {
+		sim.pose = p
+		return
+	}
+	// Find how far along the move the robot can get before it touches.
+	start := sim.pose
+	lo, hi := 0.0, 1.0
+	for i := 0; i < 10; i++ {
+		mid := (lo + hi) / 2
+		if d, _ := sim.clearance(start.lerp(p, mid).point()); d >= RobotRadius {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	sim.pose = start.lerp(p, lo)
+	// Turning in place never changes the footprint, so the robot keeps
+	// the full rotation even when it is blocked.
+	sim.pose.Heading = p.Heading
+}
+
+// bumps reports which halves of the bumper are pressed against geometry.
+func (sim *RoombaSimulator) bumps() (left, right bool) {
+	if sim.world == nil {
+		return false, false
+	}
+	center := sim.pose.point()
+	var contacts []Point
+	for _, s := range sim.world.segments {
+		contacts = append(contacts, s.closest(center))
+	}
+	// Other robots are round, so the contact is on the line between the
+	// centers.
+	for _, b := range sim.world.others(sim) {
+		c := b.pose.point()
+		if d := center.dist(c); d > 0 {
+			contacts = append(contacts, Point{
+				c.X + (center.X-c.X)*RobotRadius/d,
+				c.Y + (center.Y-c.Y)*RobotRadius/d,
+			})
+		}
+	}
+	for _, c := range contacts {
+		if center.dist(c) > RobotRadius+bumpTolerance {
+			continue
+		}
+		bearing := normalizeAngle(math.Atan2(c.Y-center.Y, c.X-center.X) - sim.pose.Heading)
+		switch {
+		case math.Abs(bearing) > bumpMaxAngle:
+		case bearing > bumpCenterAngle:
+			left = true
+		case bearing < -bumpCenterAngle:
+			right = true
+		default:
+			left, right = true, true
+		}
+	}
+	return left, right
+}
+
+// wallSignal returns the strength of the wall sensor's signal, 0-1023, and
+// whether it is strong enough to count as seeing a wall.
+func (sim *RoombaSimulator) wallSignal() (uint16, bool) {
+	if sim.world == nil {
+		return 0, false
+	}
+	origin := sim.pose.offset(RobotRadius, wallSensorBearing)
+	d := sim.world.raycast(origin, sim.pose.Heading-math.Pi/2)
+	if d >= wallSensorRange {
+		return 0, false
+	}
+	return uint16(1023 * (1 - d/wallSensorRange)), d <= wallSeenRange
+}
+
+// worldSensorValue returns the value of the sensor packets derived from the
+// robot's surroundings, and false for any other packet.
+func (sim *RoombaSimulator) worldSensorValue(packetId byte) ([]byte, bool) {
+	switch packetId {
+	case sensors.PacketBumps:
+		var bits byte
+		left, right := sim.bumps()
+		if right {
+			bits |= 1 << 0
+		}
+		if left {
+			bits |= 1 << 1
+		}
+		left, right = sim.wheelDrops()
+		if right {
+			bits |= 1 << 2
+		}
+		if left {
+			bits |= 1 << 3
+		}
+		return []byte{bits}, true
+	case sensors.PacketWall:
+		if _, seen := sim.wallSignal(); seen {
+			return []byte{1}, true
+		}
+		return []byte{0}, true
+	case sensors.PacketWallSignal:
+		signal, _ := sim.wallSignal()
+		return roomba.Pack([]interface{}{signal}), true
+	}
+	return sim.cliffSensorValue(packetId)
+}
+
+func (p Point) dist(q Point) float64 {
+	return math.Hypot(p.X-q.X, p.Y-q.Y)
+}
+
+func (p Pose) point() Point {
+	return Point{p.X, p.Y}
+}
+
+// offset returns the point at distance d from the robot's center, at the
+// given bearing relative to its heading.
+func (p Pose) offset(d, bearing float64) Point {
+	return Point{
+		p.X + d*math.Cos(p.Heading+bearing),
+		p.Y + d*math.Sin(p.Heading+bearing),
+	}
+}
+
+// lerp interpolates the position between two poses.
+func (p Pose) lerp(q Pose, t float64) Pose {
+	return Pose{
+		X:       p.X + (q.X-p.X)*t,
+		Y:       p.Y + (q.Y-p.Y)*t,
+		Heading: p.Heading,
+	}
+}
+
+type segment struct {
+	a, b Point
+}
+
+func (p Polygon) segments() []segment {
+	segs := make([]segment, len(p))
+	for i := range p {
+		segs[i] = segment{p[i], p[(i+1)%len(p)]}
+	}
+	return segs
+}
+
+// closest returns the point on the segment closest to p.
+func (s segment) closest(p Point) Point {
+	dx, dy := s.b.X-s.a.X, s.b.Y-s.a.Y
+	lenSq := dx*dx + dy*dy
+	if lenSq == 0 {
+		return s.a
+	}
+	t := ((p.X-s.a.X)*dx + (p.Y-s.a.Y)*dy) / lenSq
+	t = math.Max(0, math.Min(1, t))
+	return Point{s.a.X + t*dx, s.a.Y + t*dy}
+}
+
+// intersectRay returns the distance along a ray, with unit direction dir,
+// at which it crosses the segment.
+func (s segment) intersectRay(origin, dir Point) (float64, bool) {
+	ex, ey := s.b.X-s.a.X, s.b.Y-s.a.Y
+	denom := dir.X*ey - dir.Y*ex
+	if denom == 0 {
+		return 0, false
+	}
+	ox, oy := s.a.X-origin.X, s.a.Y-origin.Y
+	t := (ox*ey - oy*ex) / denom
+	u := (ox*dir.Y - oy*dir.X) / denom
+	if t < 0 || u < 0 || u > 1 {
+		return 0, false
+	}
+	return t, true
+}
@@ -0,0 +1,73 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+)
+
+func TestBumps(t *testing.T) {
+	tests := []struct {
+		name  string
+		start Pose
+		// The robot drives forward at 200mm/s for 10s, or stays put.
+		drive    bool
+		obstacle Polygon
+		want     Pose
+		bumps    byte
+		wall     byte
+	}{
+		{"open floor", Pose{X: 1500, Y: 1000}, false, nil,
+			Pose{X: 1500, Y: 1000}, 0, 0},
+		{"head on", Pose{X: 1500, Y: 1000}, true, nil,
+			Pose{X: 3000 - RobotRadius, Y: 1000}, 0x03, 0},
+		{"obstacle", Pose{X: 1500, Y: 1000}, true, Polygon{{2000, 500}, {2200, 500}, {2200, 1500}, {2000, 1500}},
+			Pose{X: 2000 - RobotRadius, Y: 1000}, 0x03, 0},
+		{"left", Pose{X: 1500, Y: 1000, Heading: math.Pi / 4}, true, nil,
+			Pose{X: 1500 + 1000 - RobotRadius, Y: 2000 - RobotRadius, Heading: math.Pi / 4}, 0x02, 0},
+		{"right", Pose{X: 1500, Y: 1000, Heading: -math.Pi / 4}, true, nil,
+			Pose{X: 1500 + 1000 - RobotRadius, Y: RobotRadius, Heading: -math.Pi / 4}, 0x01, 1},
+		// A wall alongside misses the bumper, but the wall sensor sees it.
+		{"wall on the right", Pose{X: 1500, Y: RobotRadius + 20}, false, nil,
+			Pose{X: 1500, Y: RobotRadius + 20}, 0, 1},
+	}
+	for _, test := range tests {
+		w := NewWorld(3000, 2000)
+		if test.obstacle != nil {
+			if err := w.AddObstacle(test.obstacle); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Start = test.start
+		s, rw, c := newTestSim(t)
+		s.SetWorld(w)
+		send(t, s, rw, oi.Start{}, oi.Safe{})
+		if test.drive {
+			send(t, s, rw, oi.DirectDrive{Right: 200, Left: 200})
+		}
+		c.Advance(10 * time.Second)
+
+		p := s.Pose()
+		if math.Hypot(p.X-test.want.X, p.Y-test.want.Y) > 2 || math.Abs(p.Heading-test.want.Heading) > 0.01 {
+			t.Errorf("%s: pose %+v, want %+v", test.name, p, test.want)
+		}
+		if got := sensor(t, rw, sensors.PacketBumps)[0]; got != test.bumps {
+			t.Errorf("%s: bumps %#02x, want %#02x", test.name, got, test.bumps)
+		}
+		if got := sensor(t, rw, sensors.PacketWall)[0]; got != test.wall {
+			t.Errorf("%s: wall %d, want %d", test.name, got, test.wall)
+		}
+		// The wheels keep turning against an obstacle, so odometry counts
+		// the whole drive.
+		want := int16(0)
+		if test.drive {
+			want = 2000
+		}
+		if got := sensor16(t, rw, sensors.PacketDistance); got != want {
+			t.Errorf("%s: distance %d, want %d", test.name, got, want)
+		}
+	}
+}
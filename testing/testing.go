@@ -3,34 +3,136 @@ package testing
 import (
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cquinn/doombot/clock"
+	"github.com/cquinn/doombot/oi"
 	"github.com/cquinn/doombot/sim"
 	"github.com/xa4a/go-roomba"
 )
 
-var roombaSim *sim.RoombaSimulator
-var mockRoombaClient *roomba.Roomba
+// virtualStart is when the virtual clocks of test robots start.
+var virtualStart = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
 
-func MakeTestRoomba() *roomba.Roomba {
-	if mockRoombaClient == nil {
-		var socket io.ReadWriter
-		roombaSim, socket = sim.MakeRoombaSim()
+// TestRoomba is a go-roomba client talking to a simulator of its own. Each
+// test can make as many as it likes, with their own sensor values and
+// clocks, or a clock shared by the robots in a world, so tests using them
+// can run in parallel.
+type TestRoomba struct {
+	*roomba.Roomba
+
+	// Sim is the simulator behind the client, so tests can set up its
+	// world and inspect its state.
+	Sim *sim.RoombaSimulator
+
+	// Clock is the simulator's clock: a virtual one for a Config with
+	// Virtual or Clock set, and otherwise the wall clock.
+	Clock clock.Clock
+
+	manual *clock.Manual
+}
+
+// Config describes a test robot. The zero Config is a robot in empty space
+// on the wall clock, with the default sensor values.
+type Config struct {
+	// World is the world the robot is put in. Robots given the same world
+	// share it and can bump into each other.
+	World *sim.World
+
+	// Sensors overrides sensor packets' values for the robot's life, in
+	// place of their simulated or default values.
+	Sensors map[byte][]byte
+
+	// Virtual runs the simulator on a virtual clock that only moves when
+	// the test calls AdvanceTime. Long scenarios such as draining the
+	// battery then run quickly and reproducibly.
+	Virtual bool
+
+	// Clock is the virtual clock to run the simulator on, made with
+	// NewClock, and implies Virtual. The robots in a World must all run on
+	// the same clock, so a virtual robot given a World must be given the
+	// Clock that the world's other robots share.
+	Clock *clock.Manual
+}
+
+// NewClock returns a virtual clock for robots to share through Config.
+func NewClock() *clock.Manual {
+	return clock.NewManual(virtualStart)
+}
 
-		mockRoombaClient = &roomba.Roomba{S: socket, StreamPaused: make(chan bool, 1)}
+// NewTestRoomba makes a test robot as described by c, which is stopped
+// when the test finishes.
+func NewTestRoomba(t testing.TB, c Config) *TestRoomba {
+	if c.Virtual && c.World != nil && c.Clock == nil {
+		t.Fatal("a virtual robot in a World needs the Config.Clock its robots share")
 	}
-	return mockRoombaClient
+	var r *TestRoomba
+	switch {
+	case c.Clock != nil:
+		r = newVirtualTestRoomba(c.Clock)
+	case c.Virtual:
+		r = newVirtualTestRoomba(NewClock())
+	default:
+		r = newRealTestRoomba()
+	}
+	t.Cleanup(r.Close)
+	for id, value := range c.Sensors {
+		if err := r.Sim.SetSensor(id, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.World != nil {
+		r.Sim.SetWorld(c.World)
+	}
+	return r
 }
 
-func ClearTestRoomba() {
-	mockRoombaClient = nil
-	roombaSim.Stop()
-	roombaSim = nil
+func newRealTestRoomba() *TestRoomba {
+	s, socket := sim.MakeRoombaSim()
+	return newTestRoomba(s, socket, clock.Real{}, nil)
 }
 
-func VerifyWritten(r *roomba.Roomba, expected []byte, t *testing.T) {
+func newVirtualTestRoomba(c *clock.Manual) *TestRoomba {
+	s, socket := sim.MakeRoombaSimWithClock(c)
+	return newTestRoomba(s, socket, c, c)
+}
+
+func newTestRoomba(s *sim.RoombaSimulator, socket io.ReadWriter, c clock.Clock, manual *clock.Manual) *TestRoomba {
+	return &TestRoomba{
+		Roomba: &roomba.Roomba{S: socket, StreamPaused: make(chan bool, 1)},
+		Sim:    s,
+		Clock:  c,
+		manual: manual,
+	}
+}
+
+// Close stops the robot's simulator, and takes it out of its world.
+func (r *TestRoomba) Close() {
+	r.Sim.SetWorld(nil)
+	r.Sim.Close()
+}
+
+// AdvanceTime moves the robot's virtual clock forward, once the simulator
+// has caught up with the commands sent so far. The robot must have been
+// made with Virtual or Clock set. A shared clock moves for all its robots,
+// so wait for the others' commands with their Sim.WaitForCommands first.
+func (r *TestRoomba) AdvanceTime(d time.Duration) {
+	if r.manual == nil {
+		panic("AdvanceTime on a test robot with a wall clock")
+	}
+	r.Sim.WaitForCommands()
+	r.manual.Advance(d)
+}
+
+// VerifyWritten checks the next bytes the simulator has read against
+// expected.
+func (r *TestRoomba) VerifyWritten(expected []byte, t testing.TB) {
 	actual := make([]byte, len(expected))
-	roombaSim.ReadBytes.Read(actual)
+	r.Sim.ReadBytes.Read(actual)
 	fmt.Println("Actual: ", actual)
 
 	if len(actual) != len(expected) {
@@ -44,3 +146,130 @@ func VerifyWritten(r *roomba.Roomba, expected []byte, t *testing.T) {
 		}
 	}
 }
+
+// VerifyCommands checks the commands the simulator has executed since the
+// last call against expected, and fails the test with both written out as
+// commands, such as DirectDrive(200,200), if they differ.
+func (r *TestRoomba) VerifyCommands(expected []oi.Command, t testing.TB) {
+	r.Sim.WaitForCommands()
+	var actual []oi.Command
+	for _, c := range r.Sim.TakeCommands() {
+		actual = append(actual, c.Command)
+	}
+
+	for i := 0; i < len(expected) || i < len(actual); i++ {
+		switch {
+		case i >= len(actual):
+			t.Errorf("command %d: expected %v, got nothing", i, expected[i])
+		case i >= len(expected):
+			t.Errorf("command %d: expected nothing, got %v", i, actual[i])
+		case expected[i].String() != actual[i].String():
+			t.Errorf("command %d: expected %v, got %v", i, expected[i], actual[i])
+		}
+	}
+}
+
+// VerifyNoProtocolErrors fails the test if the simulator has seen any
+// malformed commands, such as songs with too many notes.
+func (r *TestRoomba) VerifyNoProtocolErrors(t testing.TB) {
+	for _, err := range r.Sim.ProtocolErrors() {
+		t.Errorf("simulator protocol error: %v", err)
+	}
+}
+
+// SaveRunOnFailure arranges for pictures of the robot's run to be saved if
+// the test fails: a PNG of the whole run and an animated GIF, in
+// $SIM_ARTIFACTS or else the temporary directory.
+func (r *TestRoomba) SaveRunOnFailure(t testing.TB) {
+	s := r.Sim
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+		dir := os.Getenv("SIM_ARTIFACTS")
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		base := filepath.Join(dir, strings.Replace(t.Name(), "/", "_", -1))
+		run := s.Run()
+		if err := run.SavePNG(base + ".png"); err != nil {
+			t.Logf("saving run: %v", err)
+			return
+		}
+		if err := run.SaveGIF(base+".gif", 100); err != nil {
+			t.Logf("saving run: %v", err)
+			return
+		}
+		t.Logf("run saved to %s.png and %s.gif", base, base)
+	})
+}
+
+// The functions below work on a single shared test robot, for tests
+// written before TestRoomba. They can't run in parallel with each other.
+
+var testRoomba *TestRoomba
+
+func MakeTestRoomba() *roomba.Roomba {
+	if testRoomba == nil {
+		testRoomba = newRealTestRoomba()
+	}
+	return testRoomba.Roomba
+}
+
+// MakeVirtualTestRoomba is like MakeTestRoomba, but the simulator runs on a
+// virtual clock that only moves when the test calls AdvanceTime.
+func MakeVirtualTestRoomba() *roomba.Roomba {
+	if testRoomba == nil {
+		testRoomba = newVirtualTestRoomba(NewClock())
+	}
+	return testRoomba.Roomba
+}
+
+// AdvanceTime moves the virtual clock of MakeVirtualTestRoomba's simulator
+// forward, once the simulator has caught up with the commands sent so far.
+func AdvanceTime(d time.Duration) {
+	testRoomba.AdvanceTime(d)
+}
+
+// TestClock returns the clock behind the shared test simulator: the
+// virtual clock for MakeVirtualTestRoomba, or the wall clock for
+// MakeTestRoomba.
+func TestClock() clock.Clock {
+	if testRoomba == nil {
+		return clock.Real{}
+	}
+	return testRoomba.Clock
+}
+
+// TestSimulator returns the simulator behind MakeTestRoomba's client, so
+// tests can set up its world and inspect its state.
+func TestSimulator() *sim.RoombaSimulator {
+	return testRoomba.Sim
+}
+
+func ClearTestRoomba() {
+	testRoomba.Close()
+	testRoomba = nil
+}
+
+func VerifyWritten(r *roomba.Roomba, expected []byte, t *testing.T) {
+	testRoomba.VerifyWritten(expected, t)
+}
+
+// VerifyCommands checks the commands the shared test simulator has
+// executed since the last call against expected.
+func VerifyCommands(r *roomba.Roomba, expected []oi.Command, t *testing.T) {
+	testRoomba.VerifyCommands(expected, t)
+}
+
+// SaveRunOnFailure arranges for pictures of the shared test simulator's
+// run to be saved if the test fails. Call it after making the test Roomba.
+func SaveRunOnFailure(t *testing.T) {
+	testRoomba.SaveRunOnFailure(t)
+}
+
+// VerifyNoProtocolErrors fails the test if the shared test simulator has
+// seen any malformed commands.
+func VerifyNoProtocolErrors(t *testing.T) {
+	testRoomba.VerifyNoProtocolErrors(t)
+}
@@ -0,0 +1,54 @@
+package testing_test
+
+import (
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cquinn/doombot/oi"
+	"github.com/cquinn/doombot/sensors"
+	"github.com/cquinn/doombot/sim"
+	simtesting "github.com/cquinn/doombot/testing"
+)
+
+// bumps asks a test robot for its bumps and wheel drops packet.
+func bumps(t *testing.T, r *simtesting.TestRoomba) byte {
+	if err := oi.NewEncoder(r.S).Send(oi.Sensors{ID: sensors.PacketBumps}); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r.S, b); err != nil {
+		t.Fatal(err)
+	}
+	return b[0]
+}
+
+func TestRobotsInAWorldShareAClock(t *testing.T) {
+	w := sim.NewWorld(4000, 2000)
+	w.Start = sim.Pose{X: 1000, Y: 1000}
+	w.Starts = []sim.Pose{{X: 3000, Y: 1000, Heading: math.Pi}}
+	c := simtesting.NewClock()
+	a := simtesting.NewTestRoomba(t, simtesting.Config{World: w, Clock: c})
+	b := simtesting.NewTestRoomba(t, simtesting.Config{World: w, Clock: c})
+	for _, r := range []*simtesting.TestRoomba{a, b} {
+		if err := oi.NewEncoder(r.S).Send(oi.Start{}, oi.Safe{}, oi.DirectDrive{Right: 200, Left: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Sim.WaitForCommands()
+	a.AdvanceTime(10 * time.Second)
+
+	if a.Clock.Now() != b.Clock.Now() {
+		t.Errorf("clocks read %v and %v", a.Clock.Now(), b.Clock.Now())
+	}
+	pa, pb := a.Sim.Pose(), b.Sim.Pose()
+	if d := math.Hypot(pa.X-pb.X, pa.Y-pb.Y); math.Abs(d-2*sim.RobotRadius) > 1 {
+		t.Errorf("robots %.0fmm apart, want touching at %.0fmm", d, 2*sim.RobotRadius)
+	}
+	for name, r := range map[string]*simtesting.TestRoomba{"a": a, "b": b} {
+		if got := bumps(t, r); got&0x03 != 0x03 {
+			t.Errorf("robot %s: bumps %#02x, want both bumpers pressed", name, got)
+		}
+	}
+}
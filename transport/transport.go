@@ -0,0 +1,292 @@
+/*
+Package transport connects to a robot given its address as a URL, so that
+every tool reaches serial, networked and simulated Roombas the same way:
+
+	serial:///dev/ttyUSB0?baud=115200
+	tcp://raspberrypi:9003?timeout=5s
+	sim://sim/worlds/arena.json?drop=0.001&seed=1
+
+A tcp:// address without a port uses DefaultPort. Parse turns an address
+into a Transport carrying that backend's options, and each Transport's
+String is an address that parses back to it. Open connects to it,
+returning a Robot whichever backend was used.
+*/
+package transport
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cquinn/doombot/clock"
+	"github.com/cquinn/doombot/sim"
+	"github.com/xa4a/go-roomba"
+)
+
+const (
+	DefaultBaud    = 115200
+	DefaultTimeout = 10 * time.Second
+	// DefaultPort is the port tcpserial and roombasim serve the OI on, used
+	// for tcp:// addresses that don't give one.
+	DefaultPort = 9003
+)
+
+// Robot is a connected robot. It embeds the go-roomba client, so it can be
+// driven like one, and it knows how to hang up.
+type Robot struct {
+	*roomba.Roomba
+
+	// Clock is the robot's clock, which sensor readings should be
+	// timestamped with.
+	Clock clock.Clock
+
+	// Sim is the simulator behind a sim:// robot, and nil otherwise.
+	Sim *sim.RoombaSimulator
+
+	closer io.Closer
+}
+
+// Close hangs up on the robot, stopping it if it is simulated.
+func (r *Robot) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Transport is a way of reaching a robot.
+type Transport interface {
+	Open() (*Robot, error)
+	// String describes the transport and its options, for logging.
+	String() string
+}
+
+// Open connects to the robot at addr.
+func Open(addr string) (*Robot, error) {
+	t, err := Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	return t.Open()
+}
+
+// Parse returns the transport for addr, with its options filled in from the
+// query string and defaulted where absent.
+func Parse(addr string) (Transport, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	opts := options{q: q}
+	var t Transport
+	switch u.Scheme {
+	case "serial":
+		// serial:///dev/ttyUSB0 has an absolute path, serial://COM3 a host.
+		if u.Host+u.Path == "" {
+			return nil, fmt.Errorf("%s: no serial device", addr)
+		}
+		t = &Serial{Device: u.Host + u.Path, Baud: opts.int("baud", DefaultBaud)}
+	case "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%s: no host", addr)
+		}
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), strconv.Itoa(DefaultPort))
+		}
+		t = &TCP{Addr: host, Timeout: opts.duration("timeout", DefaultTimeout)}
+	case "sim":
+		t = &Sim{
+			// A relative world file reads as host and path.
+			World: u.Host + u.Path,
+			Faults: sim.Faults{
+				Latency:             opts.duration("latency", 0),
+				Jitter:              opts.duration("jitter", 0),
+				DropRate:            opts.float("drop"),
+				CorruptRate:         opts.float("corrupt"),
+				CorruptChecksumRate: opts.float("checksum"),
+				StallAfter:          opts.int("stallafter", 0),
+				StallFor:            opts.duration("stallfor", 0),
+				DisconnectAfter:     opts.int("disconnectafter", 0),
+				Seed:                int64(opts.int("seed", 0)),
+			},
+		}
+	case "":
+		return nil, fmt.Errorf("%s: no scheme, want serial://, tcp:// or sim://", addr)
+	default:
+		return nil, fmt.Errorf("%s: unknown scheme %q", addr, u.Scheme)
+	}
+	if opts.err != nil {
+		return nil, fmt.Errorf("%s: %v", addr, opts.err)
+	}
+	for k := range q {
+		if !opts.used[k] {
+			return nil, fmt.Errorf("%s: unknown option %q", addr, k)
+		}
+	}
+	return t, nil
+}
+
+// Serial reaches a robot plugged into a local serial port.
+type Serial struct {
+	Device string
+	Baud   int
+}
+
+func (t *Serial) Open() (*Robot, error) {
+	r := &roomba.Roomba{PortName: t.Device, StreamPaused: make(chan bool, 1)}
+	if err := r.Open(uint(t.Baud)); err != nil {
+		return nil, err
+	}
+	robot := &Robot{Roomba: r, Clock: clock.Real{}}
+	if c, ok := r.S.(io.Closer); ok {
+		robot.closer = c
+	}
+	return robot, nil
+}
+
+func (t *Serial) String() string {
+	return fmt.Sprintf("serial://%s?baud=%d", t.Device, t.Baud)
+}
+
+// TCP reaches a robot over the network, through tcpserial or roombasim.
+type TCP struct {
+	Addr    string
+	Timeout time.Duration
+}
+
+func (t *TCP) Open() (*Robot, error) {
+	conn, err := net.DialTimeout("tcp", t.Addr, t.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	r := &roomba.Roomba{S: conn, PortName: t.Addr, StreamPaused: make(chan bool, 1)}
+	return &Robot{Roomba: r, Clock: clock.Real{}, closer: conn}, nil
+}
+
+func (t *TCP) String() string {
+	return fmt.Sprintf("tcp://%s?timeout=%v", t.Addr, t.Timeout)
+}
+
+// Sim runs a simulated robot in this process, in the world described by
+// the World file, or in empty space if there is none.
+type Sim struct {
+	World  string
+	Faults sim.Faults
+}
+
+func (t *Sim) Open() (*Robot, error) {
+	var world *sim.World
+	if t.World != "" {
+		var err error
+		world, err = sim.LoadWorld(t.World)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s, rw := sim.MakeRoombaSim()
+	if world != nil {
+		s.SetWorld(world)
+	}
+	if t.Faults != (sim.Faults{}) {
+		s.SetFaults(t.Faults)
+	}
+	r := &roomba.Roomba{S: rw, PortName: t.String(), StreamPaused: make(chan bool, 1)}
+	return &Robot{Roomba: r, Clock: clock.Real{}, Sim: s, closer: s}, nil
+}
+
+// String gives the world and any faults, so that Parse returns the same
+// transport.
+func (t *Sim) String() string {
+	q := url.Values{}
+	f := t.Faults
+	setDuration(q, "latency", f.Latency)
+	setDuration(q, "jitter", f.Jitter)
+	setFloat(q, "drop", f.DropRate)
+	setFloat(q, "corrupt", f.CorruptRate)
+	setFloat(q, "checksum", f.CorruptChecksumRate)
+	setInt(q, "stallafter", f.StallAfter)
+	setDuration(q, "stallfor", f.StallFor)
+	setInt(q, "disconnectafter", f.DisconnectAfter)
+	setInt(q, "seed", int(f.Seed))
+	if len(q) == 0 {
+		return "sim://" + t.World
+	}
+	return "sim://" + t.World + "?" + q.Encode()
+}
+
+// setInt, setFloat and setDuration add an option to a query string, unless
+// it is zero and so can be left to default.
+func setInt(q url.Values, key string, v int) {
+	if v != 0 {
+		q.Set(key, strconv.Itoa(v))
+	}
+}
+
+func setFloat(q url.Values, key string, v float64) {
+	if v != 0 {
+		q.Set(key, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+}
+
+func setDuration(q url.Values, key string, v time.Duration) {
+	if v != 0 {
+		q.Set(key, v.String())
+	}
+}
+
+// options reads a transport's options from a query string, remembering the
+// first bad value and which options were asked for.
+type options struct {
+	q    url.Values
+	used map[string]bool
+	err  error
+}
+
+func (o *options) get(key string) string {
+	if o.used == nil {
+		o.used = make(map[string]bool)
+	}
+	o.used[key] = true
+	return o.q.Get(key)
+}
+
+func (o *options) int(key string, def int) int {
+	s := o.get(key)
+	if s == "" {
+		return def
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil && o.err == nil {
+		o.err = fmt.Errorf("bad %s %q", key, s)
+	}
+	return n
+}
+
+func (o *options) float(key string) float64 {
+	s := o.get(key)
+	if s == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && o.err == nil {
+		o.err = fmt.Errorf("bad %s %q", key, s)
+	}
+	return f
+}
+
+func (o *options) duration(key string, def time.Duration) time.Duration {
+	s := o.get(key)
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil && o.err == nil {
+		o.err = fmt.Errorf("bad %s %q", key, s)
+	}
+	return d
+}
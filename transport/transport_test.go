@@ -0,0 +1,68 @@
+package transport_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cquinn/doombot/sim"
+	"github.com/cquinn/doombot/transport"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		addr string
+		want transport.Transport
+	}{
+		{"serial:///dev/ttyUSB0", &transport.Serial{Device: "/dev/ttyUSB0", Baud: transport.DefaultBaud}},
+		{"serial://COM3?baud=57600", &transport.Serial{Device: "COM3", Baud: 57600}},
+		{"tcp://raspberrypi:9000?timeout=5s", &transport.TCP{Addr: "raspberrypi:9000", Timeout: 5 * time.Second}},
+		{"tcp://raspberrypi", &transport.TCP{Addr: "raspberrypi:9003", Timeout: transport.DefaultTimeout}},
+		{"tcp://[::1]", &transport.TCP{Addr: "[::1]:9003", Timeout: transport.DefaultTimeout}},
+		{"sim://", &transport.Sim{}},
+		{"sim://worlds/arena.json?drop=0.001&seed=1", &transport.Sim{
+			World:  "worlds/arena.json",
+			Faults: sim.Faults{DropRate: 0.001, Seed: 1},
+		}},
+		{"sim://?latency=5ms&jitter=1ms&corrupt=0.5&checksum=0.25&stallafter=3&stallfor=1s&disconnectafter=100",
+			&transport.Sim{Faults: sim.Faults{
+				Latency:             5 * time.Millisecond,
+				Jitter:              time.Millisecond,
+				CorruptRate:         0.5,
+				CorruptChecksumRate: 0.25,
+				StallAfter:          3,
+				StallFor:            time.Second,
+				DisconnectAfter:     100,
+			}}},
+	}
+	for _, test := range tests {
+		got, err := transport.Parse(test.addr)
+		if err != nil {
+			t.Errorf("%s: %v", test.addr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parsed as %#v, want %#v", test.addr, got, test.want)
+		}
+		again, err := transport.Parse(got.String())
+		if err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("%s: %s parsed back as %#v, %v", test.addr, got, again, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, addr := range []string{
+		"/dev/ttyUSB0",
+		"ftp://robot",
+		"serial://",
+		"tcp://",
+		"tcp://robot?timeout=soon",
+		"sim://?drop=some",
+		"sim://?speed=11",
+	} {
+		if tr, err := transport.Parse(addr); err == nil {
+			t.Errorf("%s: parsed as %v, want an error", addr, tr)
+		}
+	}
+}